@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// cacheAdminHandler serves /admin/cache. GET reports what the page cache
+// holds, and DELETE empties it, which picks up page files that were changed
+// outside the wiki
+func cacheAdminHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		writeJSON(w, http.StatusOK, cache.stats())
+	case http.MethodDelete:
+		cleared := cache.clear()
+
+		// The tag and link indexes, page counts and live viewers were worked
+		// out from the files as they were, and any of them may have changed,
+		// so every page counts as changed. So do cached pages whose files
+		// are gone
+		titles, err := everyTitle(r.Context())
+		if err != nil {
+			writeJSONServerError(w, err)
+			return
+		}
+		notify(nil, union(cleared, titles)...)
+
+		writeJSON(w, http.StatusOK, map[string]int{"cleared": len(cleared)})
+	default:
+		w.Header().Set("Allow", "GET, HEAD, DELETE")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// everyTitle returns the titles of the pages of every wiki
+func everyTitle(ctx context.Context) ([]string, error) {
+	var titles []string
+	for _, wiki := range everyWiki() {
+		list, err := store.List(context.WithValue(ctx, wikiKey{}, wiki))
+		if err != nil {
+			return nil, err
+		}
+		titles = append(titles, list...)
+	}
+	return titles, nil
+}
+
+// union returns the titles in either a or b, each once and sorted
+func union(a, b []string) []string {
+	seen := map[string]bool{}
+	for _, list := range [][]string{a, b} {
+		for _, title := range list {
+			seen[title] = true
+		}
+	}
+	return sortedKeys(seen)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCacheFlushPicksUpOutsideEdits(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Cached", "---\ntags: [old]\n---\nbody\n")
+	savePage(t, "Linker", "nothing yet\n")
+	savePage(t, "Target", "target\n")
+
+	// Everything is worked out from the pages as saved
+	for _, path := range []string{"/view/Cached", "/view/Linker", "/view/Target", "/tags/old"} {
+		if w := serve(httptest.NewRequest(http.MethodGet, path, nil)); w.Code != http.StatusOK {
+			t.Fatalf("GET %s: status = %d", path, w.Code)
+		}
+	}
+
+	// The files are edited outside the wiki, which doesn't notice
+	if err := os.WriteFile(pageFile("Cached"), []byte("---\ntags: [new]\n---\nbody\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cache.remove("Linker")
+	if err := os.WriteFile(pageFile("Linker"), []byte("see [Target]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(httptest.NewRequest(http.MethodDelete, "/admin/cache", nil))
+	var resp map[string]int
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil || resp["cleared"] != 2 {
+		t.Fatalf("DELETE /admin/cache: %d %s, want 2 cleared", w.Code, w.Body.String())
+	}
+
+	// The tag index follows the cached page's edit
+	if w := serve(httptest.NewRequest(http.MethodGet, "/tags/new", nil)); !strings.Contains(w.Body.String(), `href="/view/Cached"`) {
+		t.Errorf("/tags/new doesn't list the page tagged on disk")
+	}
+	if w := serve(httptest.NewRequest(http.MethodGet, "/tags/old", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("GET /tags/old: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	// And the backlinks follow the edit of one that wasn't cached
+	if body := serve(httptest.NewRequest(http.MethodGet, "/view/Target", nil)).Body.String(); !strings.Contains(body, `href="/view/Linker"`) {
+		t.Errorf("Target doesn't list the link added on disk")
+	}
+}
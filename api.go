@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// apiPage is the JSON representation of a page that the API reads and writes.
+// Body is the page's source, front matter included
+type apiPage struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+// apiPreview is what a ?validate=true PUT responds with: the page as it
+// would be saved, and the HTML it would render to
+type apiPreview struct {
+	apiPage
+	HTML string `json:"html"`
+}
+
+// apiError is the envelope every failed API request responds with
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// apiHandler serves /api/pages/{title}, dispatching to the handler for the
+// request method
+func apiHandler(w http.ResponseWriter, r *http.Request) {
+	title := r.PathValue("title")
+	if !titleChars.MatchString(title) {
+		apiNotFound(w, r)
+		return
+	}
+
+	if err := validateTitle(title); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Under /w/<name>/ the API reads and writes that wiki's pages
+	title = canonicalTitle(wikiTitle(wikiFromContext(r.Context()), title))
+
+	// Reading is open to everyone, changing pages needs the same credentials
+	// as the edit form
+	if r.Method != http.MethodGet && !authorized(r) {
+		requestAuth(w)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		apiGetPage(w, r, title)
+	case http.MethodPut:
+		apiPutPage(w, r, title)
+	case http.MethodDelete:
+		apiDeletePage(w, r, title)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// apiGetPage responds with the page with the given title, or 404 if there
+// isn't one
+func apiGetPage(w http.ResponseWriter, r *http.Request, title string) {
+	p, err := store.Load(r.Context(), title)
+	if errors.Is(err, os.ErrNotExist) {
+		writeJSONError(w, http.StatusNotFound, "page not found")
+		return
+	}
+	if err != nil {
+		writeJSONServerError(w, err)
+		return
+	}
+
+	if p.Expired(time.Now()) {
+		writeJSONError(w, http.StatusGone, "page has expired")
+		return
+	}
+
+	if !canView(r, p) {
+		requestAuth(w)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAPIPage(p))
+}
+
+// newAPIPage returns the JSON representation of p. The title is the one in
+// the page's URL, without the name of its wiki
+func newAPIPage(p *Page) apiPage {
+	_, name := splitWiki(p.Title)
+	return apiPage{Title: name, Body: string(p.Source())}
+}
+
+// apiPutPage creates or replaces the page with the given title from a JSON
+// request body. It responds 201 when the page is new and 200 when an existing
+// page was overwritten. With ?validate=true the page goes through every check
+// a save would make and is rendered, but nothing is written
+func apiPutPage(w http.ResponseWriter, r *http.Request, title string) {
+	// Applies the same size limit as the HTML save form
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+	var in apiPage
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("page body cannot be larger than %d bytes", maxBodySize))
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+
+	// The title is taken from the URL, a different one in the body is most
+	// likely a mistake by the client
+	if _, name := splitWiki(title); in.Title != "" && canonicalTitle(in.Title) != name {
+		writeJSONError(w, http.StatusBadRequest, "title in body does not match the URL")
+		return
+	}
+
+	p := pageFromSource(title, normalizeBody(fixUTF8([]byte(in.Body))))
+	if err := validatePage(p); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := checkUnlocked(r.Context(), title); err != nil {
+		writeJSONError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err := checkRoom(r.Context(), title); err != nil {
+		writeJSONError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	if r.URL.Query().Get("validate") == "true" {
+		writeJSON(w, http.StatusOK, apiPreview{
+			apiPage: newAPIPage(p),
+			HTML:    string(renderMarkdown(p.Wiki(), p.Body)),
+		})
+		return
+	}
+
+	status := http.StatusOK
+	if !pageExists(r.Context(), title) {
+		status = http.StatusCreated
+	}
+
+	if err := store.Save(r.Context(), p); err != nil {
+		writeJSONServerError(w, err)
+		return
+	}
+	createStubs(r.Context(), p)
+
+	writeJSON(w, status, newAPIPage(p))
+}
+
+// apiDeletePage moves the page with the given title to the trash, responding
+// 204 on success, 403 if the page is locked, 404 if there was nothing to
+// remove or 409 if an earlier page with the title is still in the trash
+func apiDeletePage(w http.ResponseWriter, r *http.Request, title string) {
+	if err := checkUnlocked(r.Context(), title); err != nil {
+		writeJSONError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	err := store.Delete(r.Context(), title)
+	if errors.Is(err, os.ErrNotExist) {
+		writeJSONError(w, http.StatusNotFound, "page not found")
+		return
+	}
+	if errors.Is(err, os.ErrExist) {
+		writeJSONError(w, http.StatusConflict, "an earlier page with this title is already in the trash")
+		return
+	}
+	if err != nil {
+		writeJSONServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiNotFound answers requests for anything under /api/ that isn't part of
+// the API, in JSON rather than with the html 404 page
+func apiNotFound(w http.ResponseWriter, r *http.Request) {
+	writeJSONError(w, http.StatusNotFound, "not found")
+}
+
+// writeJSON sends v encoded as JSON with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeJSONServerError logs err like serverError does, and answers with a
+// generic apiError envelope
+func writeJSONServerError(w http.ResponseWriter, err error) {
+	id := w.Header().Get(requestIDHeader)
+	log.Printf("request %s failed: %v", id, err)
+	writeJSONError(w, http.StatusInternalServerError, "internal error, quote request ID "+id+" when reporting it")
+}
+
+// writeJSONError sends an apiError envelope with the given status code
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: message})
+}
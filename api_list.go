@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiPageInfo is how GET /api/pages describes each page, without its body.
+// Size is the length of the page's source in bytes
+type apiPageInfo struct {
+	Title    string    `json:"title"`
+	Size     int       `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// apiListPages serves GET /api/pages, a JSON array describing the pages the
+// request may see. ?prefix= keeps only titles starting with it, and
+// ?sort=modified lists the most recently changed first instead of by title.
+// The list is paged with ?page= and ?size= like the index, the total number
+// of pages matching goes in X-Total-Count, and a Link header points to the
+// next page of them while there is one
+func apiListPages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	order := query.Get("sort")
+	if order != "" && order != "title" && order != "modified" {
+		writeJSONError(w, http.StatusBadRequest, "sort must be title or modified")
+		return
+	}
+
+	titles, err := store.List(r.Context())
+	if err != nil {
+		writeJSONServerError(w, err)
+		return
+	}
+
+	prefix := query.Get("prefix")
+	infos := map[string]apiPageInfo{}
+	var matched []string
+	for _, title := range titles {
+		_, name := splitWiki(title)
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		// The page may have been deleted since it was listed
+		p, err := store.Load(r.Context(), title)
+		if err != nil || !canView(r, p) {
+			continue
+		}
+		infos[title] = apiPageInfo{Title: name, Size: len(p.Source()), Modified: p.ModTime}
+		matched = append(matched, title)
+	}
+
+	// Titles come from the store sorted already
+	if order == "modified" {
+		sort.SliceStable(matched, func(i, j int) bool { return infos[matched[i]].Modified.After(infos[matched[j]].Modified) })
+	}
+
+	page, _ := strconv.Atoi(query.Get("page"))
+	size, _ := strconv.Atoi(query.Get("size"))
+	data := paginate(matched, page, size)
+
+	list := make([]apiPageInfo, 0, len(data.Titles))
+	for _, entry := range data.Titles {
+		list = append(list, infos[entry.Title])
+	}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(data.Total))
+	if data.HasNext() {
+		next := url.Values{"page": {strconv.Itoa(data.Next())}, "size": {strconv.Itoa(data.Size)}}
+		if prefix != "" {
+			next.Set("prefix", prefix)
+		}
+		if order != "" {
+			next.Set("sort", order)
+		}
+		w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, wikiPath(wikiFromContext(r.Context()), "/api/pages"), next.Encode()))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
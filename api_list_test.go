@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// apiTitles gets /api/pages with the given query and returns the titles it
+// lists, in order
+func apiTitles(t *testing.T, query string) ([]string, *httptest.ResponseRecorder) {
+	t.Helper()
+
+	w := serve(httptest.NewRequest(http.MethodGet, "/api/pages"+query, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /api/pages%s: status = %d, want %d", query, w.Code, http.StatusOK)
+	}
+	var list []apiPageInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("GET /api/pages%s isn't a JSON list: %v", query, err)
+	}
+	titles := make([]string, len(list))
+	for i, info := range list {
+		titles[i] = info.Title
+	}
+	return titles, w
+}
+
+func TestAPIListPages(t *testing.T) {
+	useTempWiki(t)
+	base := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	savePagesAt(t, map[string]time.Time{
+		"Go_Server": base,
+		"Go_Client": base.Add(2 * time.Hour),
+		"Rust":      base.Add(time.Hour),
+	})
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "Go_Client Go_Server Rust"},
+		{"?sort=title", "Go_Client Go_Server Rust"},
+		{"?sort=modified", "Go_Client Rust Go_Server"},
+		{"?prefix=Go_", "Go_Client Go_Server"},
+		{"?prefix=Go_&sort=modified", "Go_Client Go_Server"},
+		{"?prefix=go", ""},
+		{"?prefix=Nothing", ""},
+	}
+	for _, tt := range tests {
+		if got, _ := apiTitles(t, tt.query); strings.Join(got, " ") != tt.want {
+			t.Errorf("GET /api/pages%s = %v, want %s", tt.query, got, tt.want)
+		}
+	}
+
+	// Each page is described by its size and when it changed
+	w := serve(httptest.NewRequest(http.MethodGet, "/api/pages?prefix=Rust", nil))
+	var list []apiPageInfo
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list) != 1 || list[0].Size != len("Rust") || !list[0].Modified.Equal(base.Add(time.Hour)) {
+		t.Errorf("Rust is described as %+v", list)
+	}
+
+	if w := serve(httptest.NewRequest(http.MethodGet, "/api/pages?sort=size", nil)); w.Code != http.StatusBadRequest {
+		t.Errorf("sorting by size: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestAPIListPagesPaging(t *testing.T) {
+	useTempWiki(t)
+	for _, title := range []string{"A", "B", "C"} {
+		savePage(t, title, title)
+	}
+
+	got, w := apiTitles(t, "?size=2")
+	if strings.Join(got, " ") != "A B" || w.Header().Get("X-Total-Count") != "3" {
+		t.Errorf("first page = %v of %s", got, w.Header().Get("X-Total-Count"))
+	}
+	if link := w.Header().Get("Link"); link != `</api/pages?page=2&size=2>; rel="next"` {
+		t.Errorf("Link = %q", link)
+	}
+
+	got, w = apiTitles(t, "?size=2&page=2")
+	if strings.Join(got, " ") != "C" || w.Header().Get("Link") != "" {
+		t.Errorf("last page = %v, Link %q", got, w.Header().Get("Link"))
+	}
+}
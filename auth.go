@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// authUser and authPasswordHash are the credentials required to change pages.
+// The hash is the hex encoded SHA-256 of the password, as printed by
+// `printf %s password | sha256sum`. When authUser is empty the wiki is open to
+// everyone
+var (
+	authUser         string
+	authPasswordHash string
+)
+
+// authEnabled reports whether credentials have been configured
+func authEnabled() bool {
+	return authUser != ""
+}
+
+// authorized reports whether the request carries the configured Basic Auth
+// credentials, or auth is turned off altogether. Both parts are compared in
+// constant time so the response time leaks nothing about how close a guess was
+func authorized(r *http.Request) bool {
+	if !authEnabled() {
+		return true
+	}
+
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(password))
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(authUser)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(authPasswordHash))) == 1
+	return userOK && passOK
+}
+
+// requestAuth responds with 401 Unauthorized and a WWW-Authenticate header,
+// which makes browsers prompt for a username and password
+func requestAuth(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="wiki", charset="UTF-8"`)
+	http.Error(w, "you need to log in to do that", http.StatusUnauthorized)
+}
+
+// canView reports whether the request may see the given page. Private pages
+// need the same credentials as editing, every other page is public. Nobody
+// sees an expired page, so it drops out of every listing
+func canView(r *http.Request, p *Page) bool {
+	return canViewMeta(r, p.Meta)
+}
+
+// canViewMeta is canView for a page only known by its front matter, like the
+// ones kept in the tag and link indexes
+func canViewMeta(r *http.Request, m pageMeta) bool {
+	if !m.Expires.IsZero() && !time.Now().Before(m.Expires) {
+		return false
+	}
+	return !m.Private || authorized(r)
+}
+
+// checkPageAccess makes sure the request may see the page with the given
+// title, answering 410 if it has expired or 401 if it may not. A page that
+// doesn't exist, such as one that has been deleted, hides nothing
+func checkPageAccess(w http.ResponseWriter, r *http.Request, title string) bool {
+	p, err := store.Load(r.Context(), title)
+	if err == nil && !checkNotExpired(w, p) {
+		return false
+	}
+	if err == nil && !canView(r, p) {
+		requestAuth(w)
+		return false
+	}
+	return true
+}
+
+// requireAuth is middleware that only lets requests through to next when
+// they are authorized
+func requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) {
+			requestAuth(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
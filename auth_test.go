@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// useAuth requires the given credentials to change pages for the length of
+// the test
+func useAuth(t *testing.T, user, password string) {
+	t.Helper()
+
+	oldUser, oldHash := authUser, authPasswordHash
+	sum := sha256.Sum256([]byte(password))
+	authUser, authPasswordHash = user, hex.EncodeToString(sum[:])
+	t.Cleanup(func() { authUser, authPasswordHash = oldUser, oldHash })
+}
+
+// withBasicAuth sets the request's credentials, unless user is empty
+func withBasicAuth(r *http.Request, user, password string) *http.Request {
+	if user != "" {
+		r.SetBasicAuth(user, password)
+	}
+	return r
+}
+
+func TestAuthGatesChanges(t *testing.T) {
+	useTempWiki(t)
+	useAuth(t, "admin", "secret")
+
+	tests := []struct {
+		name           string
+		user, password string
+		authorized     bool
+	}{
+		{"no credentials", "", "", false},
+		{"wrong password", "admin", "guess", false},
+		{"wrong user", "someone", "secret", false},
+		{"right credentials", "admin", "secret", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			savePage(t, "Gated", "body")
+			requests := []*http.Request{
+				httptest.NewRequest(http.MethodGet, "/edit/Gated", nil),
+				postForm("/save/Gated", nil),
+				postForm("/delete/Gated", nil),
+			}
+
+			for _, r := range requests {
+				w := serve(withBasicAuth(r, tt.user, tt.password))
+				if !tt.authorized {
+					if w.Code != http.StatusUnauthorized {
+						t.Errorf("%s %s: status = %d, want %d", r.Method, r.URL.Path, w.Code, http.StatusUnauthorized)
+					}
+					if w.Header().Get("WWW-Authenticate") == "" {
+						t.Errorf("%s %s: no WWW-Authenticate header", r.Method, r.URL.Path)
+					}
+					continue
+				}
+				if w.Code == http.StatusUnauthorized {
+					t.Errorf("%s %s: refused the right credentials", r.Method, r.URL.Path)
+				}
+			}
+		})
+	}
+
+	// Reading stays public
+	savePage(t, "Public", "body")
+	if w := serve(httptest.NewRequest(http.MethodGet, "/view/Public", nil)); w.Code != http.StatusOK {
+		t.Errorf("view without credentials: status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestAuthDisabledLeavesChangesOpen(t *testing.T) {
+	useTempWiki(t)
+	oldUser, oldHash := authUser, authPasswordHash
+	authUser, authPasswordHash = "", ""
+	defer func() { authUser, authPasswordHash = oldUser, oldHash }()
+
+	if w := serve(httptest.NewRequest(http.MethodGet, "/edit/Open", nil)); w.Code != http.StatusOK {
+		t.Errorf("edit: status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestCanViewPrivatePages(t *testing.T) {
+	useAuth(t, "admin", "secret")
+	public := &Page{Title: "Open"}
+	private := &Page{Title: "Hidden", Meta: pageMeta{Private: true}}
+
+	anonymous := httptest.NewRequest(http.MethodGet, "/view/Hidden", nil)
+	loggedIn := withBasicAuth(httptest.NewRequest(http.MethodGet, "/view/Hidden", nil), "admin", "secret")
+
+	tests := []struct {
+		name string
+		r    *http.Request
+		p    *Page
+		want bool
+	}{
+		{"public anonymous", anonymous, public, true},
+		{"public logged in", loggedIn, public, true},
+		{"private anonymous", anonymous, private, false},
+		{"private logged in", loggedIn, private, true},
+	}
+	for _, tt := range tests {
+		if got := canView(tt.r, tt.p); got != tt.want {
+			t.Errorf("%s: canView = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPrivatePageNeedsLogin(t *testing.T) {
+	useTempWiki(t)
+	useAuth(t, "admin", "secret")
+	savePage(t, "Hidden", "---\nprivate: true\n---\nsecret words\n")
+
+	for _, path := range []string{"/view/Hidden", "/raw/Hidden", "/history/Hidden", "/api/pages/Hidden"} {
+		w := serve(httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s: status = %d, want %d", path, w.Code, http.StatusUnauthorized)
+		}
+		if strings.Contains(w.Body.String(), "secret words") {
+			t.Errorf("GET %s leaked the page", path)
+		}
+	}
+
+	w := serve(withBasicAuth(httptest.NewRequest(http.MethodGet, "/view/Hidden", nil), "admin", "secret"))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "secret words") {
+		t.Errorf("GET /view/Hidden logged in: status = %d", w.Code)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// autoStub creates a stub page for every [SomePage] link to a page that
+// doesn't exist yet whenever a page is saved, so following a link always
+// leads somewhere. Stubs start out like any new page in the editor, with
+// -new-template or an empty body
+var autoStub = false
+
+// createStubs creates the stubs for the pages p links to that are missing.
+// Stubs themselves aren't searched for links, so a template that links to
+// pages can't set off a chain of them. A page made in the moment between the
+// check and the stub being saved could still be replaced by the stub, which
+// is as close as the store lets this come to creating a page only if it's
+// missing
+func createStubs(ctx context.Context, p *Page) {
+	if !autoStub {
+		return
+	}
+
+	for _, name := range pageLinks(p.Wiki(), p.Body) {
+		title := canonicalTitle(wikiTitle(p.Wiki(), name))
+		if title == p.Title || validateTitle(name) != nil || pageExists(ctx, title) {
+			continue
+		}
+
+		// Stubs count towards -max-pages like pages made by hand
+		if err := checkRoom(ctx, title); err != nil {
+			log.Printf("no stub for %s: %v", title, err)
+			return
+		}
+
+		if err := store.Save(ctx, pageFromSource(title, newPageTemplate)); err != nil {
+			log.Printf("could not create a stub for %s: %v", title, err)
+			continue
+		}
+		log.Printf("created a stub for %s, linked from %s", title, p.Title)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// useAutoStub turns on stubs for missing links for the length of the test,
+// starting them from template
+func useAutoStub(t *testing.T, template string) {
+	t.Helper()
+
+	oldStub, oldTemplate := autoStub, newPageTemplate
+	autoStub, newPageTemplate = true, []byte(template)
+	t.Cleanup(func() { autoStub, newPageTemplate = oldStub, oldTemplate })
+}
+
+func TestAutoStubCreatesMissingPages(t *testing.T) {
+	useTempWiki(t)
+	useAutoStub(t, "stub linking to [Chain]\n")
+	savePage(t, "Exists", "keep me\n")
+
+	body := "See [Exists], [Missing], [Source] and [Missing] again.\n"
+	if w := serve(postForm("/save/Source", url.Values{"body": {body}})); w.Code != http.StatusFound {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusFound)
+	}
+
+	// The missing target gets a stub from the template
+	p, err := loadPage("Missing")
+	if err != nil {
+		t.Fatalf("no stub for the missing link: %v", err)
+	}
+	if string(p.Source()) != "stub linking to [Chain]\n" {
+		t.Errorf("the stub is %q, want the template", p.Source())
+	}
+
+	// The existing page is left as it was, and the page saved isn't replaced
+	// by a stub of itself
+	if p, _ := loadPage("Exists"); string(p.Body) != "keep me\n" {
+		t.Errorf("the existing page was overwritten with %q", p.Body)
+	}
+	if p, _ := loadPage("Source"); string(p.Body) != body {
+		t.Errorf("the saved page became %q", p.Body)
+	}
+
+	// Stubs don't stub their own links, so there is no chain
+	if pageExists(context.Background(), "Chain") {
+		t.Error("a stub's link was stubbed too")
+	}
+}
+
+func TestAutoStubOff(t *testing.T) {
+	useTempWiki(t)
+
+	serve(postForm("/save/Source", url.Values{"body": {"See [Missing].\n"}}))
+	if pageExists(context.Background(), "Missing") {
+		t.Error("a stub was created without -autostub")
+	}
+}
+
+func TestAutoStubRespectsMaxPages(t *testing.T) {
+	useTempWiki(t)
+	useAutoStub(t, "")
+	useMaxPages(t, 2)
+
+	serve(postForm("/save/Source", url.Values{"body": {"[First] [Second]\n"}}))
+	if !pageExists(context.Background(), "First") || pageExists(context.Background(), "Second") {
+		t.Errorf("stubs went past -max-pages: First %v, Second %v",
+			pageExists(context.Background(), "First"), pageExists(context.Background(), "Second"))
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxImportSize is the largest zip archive, in bytes, that importHandler
+// accepts
+var maxImportSize int64 = 32 << 20
+
+// backupHandler streams a zip archive of every page to the client. Each page
+// is a "<title><fileExt>" entry at the top of the archive, without the name
+// of its wiki so the archive can be imported into any of them. Adding ?history=1
+// also includes earlier versions under "history/<title>/". The archive is
+// written straight to the response as it is built, so it is never held in
+// memory as a whole
+func backupHandler(w http.ResponseWriter, r *http.Request) {
+	titles, err := store.List(r.Context())
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	withHistory := r.URL.Query().Get("history") == "1"
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename=wiki.zip")
+
+	zw := zip.NewWriter(w)
+	for _, title := range titles {
+		// Nobody is left to receive the rest of the archive
+		if r.Context().Err() != nil {
+			return
+		}
+
+		p, err := store.Load(r.Context(), title)
+
+		// The page may have been deleted since it was listed, and private
+		// pages are only exported for those who could view them
+		if err != nil || !canView(r, p) {
+			continue
+		}
+
+		// Once the first entry is written the 200 has been sent, so a failure
+		// part way through can only be logged and the archive cut short
+		_, name := splitWiki(title)
+		if err := writeZipEntry(zw, name+fileExt, p.ModTime, p.Source()); err != nil {
+			log.Printf("export: %v", err)
+			return
+		}
+
+		if withHistory {
+			if err := writeHistoryEntries(zw, title); err != nil {
+				log.Printf("export: %v", err)
+				return
+			}
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		log.Printf("export: %v", err)
+	}
+}
+
+// writeHistoryEntries adds every earlier version of a page to the archive
+func writeHistoryEntries(zw *zip.Writer, title string) error {
+	revs, err := listRevisions(title)
+	if err != nil {
+		return err
+	}
+
+	_, page := splitWiki(title)
+	for _, rev := range revs {
+		p, err := loadRevision(title, rev.ID)
+		if err != nil {
+			continue
+		}
+
+		name := path.Join("history", page, strconv.FormatInt(rev.ID, 10)+fileExt)
+		if err := writeZipEntry(zw, name, rev.Time, p.Source()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeZipEntry adds a single compressed file to the archive
+func writeZipEntry(zw *zip.Writer, name string, modified time.Time, body []byte) error {
+	f, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(body)
+	return err
+}
+
+// importPage is the data rendered by import.html. Imported and Skipped are
+// only filled in after an archive has been uploaded
+type importPage struct {
+	Wiki      string
+	CSRFToken string
+	Done      bool
+	Imported  []string
+	Skipped   []skippedEntry
+}
+
+// skippedEntry is an archive entry that wasn't imported, and why
+type skippedEntry struct {
+	Name   string
+	Reason string
+}
+
+// importHandler shows a form for uploading a zip archive like the one
+// /export produces, and on POST saves every page in it, overwriting pages
+// that already exist. Entries that aren't pages, like history, or whose names
+// aren't valid titles are skipped and listed in the summary
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	data := importPage{Wiki: wikiFromContext(r.Context()), CSRFToken: csrfToken(w, r)}
+
+	if r.Method != http.MethodPost {
+		renderTemplate(w, "import", data)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		http.Error(w, fmt.Sprintf("could not read the upload, archives can be at most %d bytes: %v", maxImportSize, err), http.StatusBadRequest)
+		return
+	}
+
+	if !checkCSRF(r) {
+		http.Error(w, "invalid or missing CSRF token, reload the page and try again", http.StatusForbidden)
+		return
+	}
+
+	file, header, err := r.FormFile("archive")
+	if err != nil {
+		http.Error(w, "no archive was uploaded", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	zr, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		http.Error(w, "the upload is not a valid zip archive", http.StatusBadRequest)
+		return
+	}
+
+	for _, f := range zr.File {
+		// Directories carry no content of their own
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		title, reason := importEntry(r.Context(), f)
+		if reason != "" {
+			data.Skipped = append(data.Skipped, skippedEntry{Name: f.Name, Reason: reason})
+			continue
+		}
+		data.Imported = append(data.Imported, title)
+	}
+
+	data.Done = true
+	renderTemplate(w, "import", data)
+}
+
+// importEntry saves a single archive entry as a page. It returns the page's
+// title, or the reason the entry was skipped
+func importEntry(ctx context.Context, f *zip.File) (string, string) {
+	// Pages sit at the top of the archive, so any path at all, including the
+	// "../" of a traversal attempt, means this isn't one
+	if strings.HasPrefix(f.Name, "history/") {
+		return "", "page history is not imported"
+	}
+	if strings.ContainsAny(f.Name, `/\`) || strings.Contains(f.Name, "..") {
+		return "", "unsafe file name"
+	}
+
+	if !strings.HasSuffix(f.Name, fileExt) {
+		return "", "not a " + fileExt + " file"
+	}
+	title := strings.TrimSuffix(f.Name, fileExt)
+	if err := validateTitle(title); err != nil {
+		return "", err.Error()
+	}
+
+	if f.UncompressedSize64 > uint64(maxBodySize) {
+		return "", "larger than the page size limit"
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return "", err.Error()
+	}
+	defer rc.Close()
+
+	// The size in the header can lie, so the read is capped as well
+	body, err := io.ReadAll(io.LimitReader(rc, maxBodySize+1))
+	if err != nil {
+		return "", err.Error()
+	}
+	if int64(len(body)) > maxBodySize {
+		return "", "larger than the page size limit"
+	}
+
+	p := pageFromSource(canonicalTitle(wikiTitle(wikiFromContext(ctx), title)), normalizeBody(fixUTF8(body)))
+	if err := validatePage(p); err != nil {
+		return "", err.Error()
+	}
+	if err := checkUnlocked(ctx, p.Title); err != nil {
+		return "", err.Error()
+	}
+	if err := checkRoom(ctx, p.Title); err != nil {
+		return "", err.Error()
+	}
+
+	if err := store.Save(ctx, p); err != nil {
+		log.Printf("import %s: %v", f.Name, err)
+		return "", "could not be saved"
+	}
+	return p.Title, ""
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// readZip opens the archive in body and returns the contents of each entry by
+// name
+func readZip(t *testing.T, body []byte) map[string]string {
+	t.Helper()
+
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("response is not a zip archive: %v", err)
+	}
+
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(data)
+	}
+	return files
+}
+
+func TestExportArchive(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Alpha", "first draft\n")
+	savePage(t, "Alpha", "alpha body\n")
+	savePage(t, "Bravo", "bravo body\n")
+
+	w := serve(httptest.NewRequest(http.MethodGet, "/export", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get("Content-Disposition"); got != "attachment; filename=wiki.zip" {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+
+	files := readZip(t, w.Body.Bytes())
+	want := map[string]string{"Alpha" + fileExt: "alpha body\n", "Bravo" + fileExt: "bravo body\n"}
+	if len(files) != len(want) {
+		t.Errorf("archive has %d entries, want %d: %v", len(files), len(want), files)
+	}
+	for name, body := range want {
+		if files[name] != body {
+			t.Errorf("%s = %q, want %q", name, files[name], body)
+		}
+	}
+
+	// Earlier versions are only included when asked for
+	w = serve(httptest.NewRequest(http.MethodGet, "/export?history=1", nil))
+	var history []string
+	for name, body := range readZip(t, w.Body.Bytes()) {
+		if strings.HasPrefix(name, "history/Alpha/") {
+			history = append(history, body)
+		}
+	}
+	if len(history) != 1 || history[0] != "first draft\n" {
+		t.Errorf("history entries = %q, want the first draft", history)
+	}
+}
+
+func TestImportNormalizesPages(t *testing.T) {
+	useTempWiki(t)
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	f, err := zw.Create("Alpha" + fileExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(f, "first line  \r\nsecond line\t\r\n\r\n\r\n")
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField(csrfField, testToken())
+	part, err := mw.CreateFormFile("archive", "wiki.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(archive.Bytes())
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/import", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r.AddCookie(&http.Cookie{Name: csrfCookie, Value: testToken()})
+	if w := serve(r); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	p, err := loadPage("Alpha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "first line\nsecond line\n"; string(p.Body) != want {
+		t.Errorf("imported body = %q, want %q", p.Body, want)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// basePath is the path the wiki is mounted under, like /wiki when a proxy
+// serves it from https://host/wiki/. Requests have it stripped before they
+// are routed, and every URL the wiki generates starts with it. Empty mounts
+// the wiki at the root
+var basePath = ""
+
+// validateBasePath checks that -base-path is a path starting with a slash,
+// without a trailing one or anything that isn't part of a path. A lone "/" is
+// allowed and means the root, which is what the trimming below turns it into
+func validateBasePath(path string) error {
+	if !strings.HasPrefix(path, "/") {
+		return errors.New("it must start with a slash, like /wiki")
+	}
+	if strings.ContainsAny(path, "?#") || strings.Contains(path, "//") {
+		return errors.New("it can only be a path, like /wiki")
+	}
+	return nil
+}
+
+// withBasePath is middleware that strips basePath from requests before they
+// reach next, so routes are matched as if the wiki were at the root. The
+// mount point itself redirects to the index under it, and anything outside
+// it isn't the wiki's
+func withBasePath(next http.Handler) http.Handler {
+	if basePath == "" {
+		return next
+	}
+
+	strip := http.StripPrefix(basePath, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == basePath {
+			http.Redirect(w, r, basePath+"/", http.StatusMovedPermanently)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, basePath+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		strip.ServeHTTP(w, r)
+	})
+}
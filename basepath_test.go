@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// useBasePath mounts the wiki under path for the length of the test
+func useBasePath(t *testing.T, path string) {
+	t.Helper()
+
+	old := basePath
+	basePath = path
+	t.Cleanup(func() { basePath = old })
+}
+
+func TestBasePathRouting(t *testing.T) {
+	useTempWiki(t)
+	useBasePath(t, "/wiki")
+	savePage(t, "Foo", "See [Bar].\n")
+
+	tests := []struct {
+		path     string
+		status   int
+		location string
+	}{
+		{"/wiki", http.StatusMovedPermanently, "/wiki/"},
+		{"/wiki/", http.StatusOK, ""},
+		{"/wiki/view/Foo", http.StatusOK, ""},
+		{"/wiki/view/Missing", http.StatusFound, "/wiki/edit/Missing"},
+		{"/view/Foo", http.StatusNotFound, ""},
+		{"/wikifoo/view/Foo", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := serve(httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.status || w.Header().Get("Location") != tt.location {
+			t.Errorf("GET %s: %d to %q, want %d to %q", tt.path, w.Code, w.Header().Get("Location"), tt.status, tt.location)
+		}
+	}
+
+	// Saves redirect under the base path
+	w := serve(postForm("/wiki/save/Foo", url.Values{"body": {"changed"}}))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/wiki/view/Foo" {
+		t.Errorf("saving: %d to %q, want %d to /wiki/view/Foo", w.Code, w.Header().Get("Location"), http.StatusFound)
+	}
+}
+
+func TestBasePathLinks(t *testing.T) {
+	useTempWiki(t)
+	useBasePath(t, "/wiki")
+	savePage(t, "Foo", "See [Bar].\n")
+
+	body := serve(httptest.NewRequest(http.MethodGet, "/wiki/view/Foo", nil)).Body.String()
+	for _, link := range []string{`href="/wiki/edit/Foo"`, `href="/wiki/view/Bar"`, `href="/wiki/static/style.css"`, `href="/wiki/index"`} {
+		if !strings.Contains(body, link) {
+			t.Errorf("the view has no %s", link)
+		}
+	}
+	if strings.Contains(body, `href="/edit/`) || strings.Contains(body, `href="/static/`) {
+		t.Errorf("the view has links outside the base path")
+	}
+}
+
+func TestBasePathRobots(t *testing.T) {
+	useBasePath(t, "/wiki")
+
+	body := serve(httptest.NewRequest(http.MethodGet, "/wiki/robots.txt", nil)).Body.String()
+	for _, line := range []string{"Disallow: /wiki/edit/", "Disallow: /wiki/history/"} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("robots.txt has no %q line:\n%s", line, body)
+		}
+	}
+}
+
+func TestValidateBasePath(t *testing.T) {
+	for path, ok := range map[string]bool{
+		"/wiki":     true,
+		"/a/b":      true,
+		"/":         true,
+		"wiki":      false,
+		"/wiki?x=1": false,
+		"/wiki#top": false,
+		"//wiki":    false,
+	} {
+		if err := validateBasePath(path); (err == nil) != ok {
+			t.Errorf("validateBasePath(%q) = %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import "strings"
+
+// breadcrumbSep splits titles into a hierarchy shown as breadcrumbs above
+// each page, so with "_" the page Projects_Wiki_Setup links up to Projects
+// and Projects_Wiki. Titles can only use word characters, so the separator
+// has to be made of them too. Empty turns breadcrumbs off
+var breadcrumbSep = ""
+
+// maxTitleDepth is how many levels breadcrumbSep can split a title into, so
+// hierarchies stay something a person could navigate. 0 means there is no
+// limit, and titles of a single level are never affected
+var maxTitleDepth = 8
+
+// breadcrumb is one level above a page in its title's hierarchy. URL is
+// empty for the last crumb, which is the page itself
+type breadcrumb struct {
+	Name string
+	URL  string
+}
+
+// titleLevel is one level of a title split at breadcrumbSep. Title is the
+// title of the page at that level, everything up to and including it
+type titleLevel struct {
+	Name  string
+	Title string
+}
+
+// titleLevels splits a page's own title, without its wiki, at breadcrumbSep.
+// Separators at either end or next to each other don't start a level of their
+// own. Without a separator every title is a single level
+func titleLevels(name string) []titleLevel {
+	if breadcrumbSep == "" {
+		return []titleLevel{{Name: name, Title: name}}
+	}
+
+	var levels []titleLevel
+	start := 0
+	for start < len(name) {
+		end := len(name)
+		if i := strings.Index(name[start:], breadcrumbSep); i >= 0 {
+			end = start + i
+		}
+
+		if end > start {
+			levels = append(levels, titleLevel{Name: name[start:end], Title: name[:end]})
+		}
+		start = end + len(breadcrumbSep)
+	}
+	return levels
+}
+
+// breadcrumbs returns a crumb for each level of the title of a page. Each
+// links to the page titled by everything up to it, whether or not that page
+// exists, since following the link offers to create it. A title with a single
+// level has no crumbs
+func breadcrumbs(title string) []breadcrumb {
+	wiki, name := splitWiki(title)
+	levels := titleLevels(name)
+	if len(levels) < 2 {
+		return nil
+	}
+
+	crumbs := make([]breadcrumb, len(levels))
+	for i, level := range levels {
+		crumbs[i] = breadcrumb{Name: level.Name, URL: pageURL("view", wikiTitle(wiki, level.Title))}
+	}
+	crumbs[len(crumbs)-1].URL = ""
+	return crumbs
+}
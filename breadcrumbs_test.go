@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// useBreadcrumbSep splits titles at sep for the length of the test
+func useBreadcrumbSep(t *testing.T, sep string) {
+	t.Helper()
+
+	old := breadcrumbSep
+	breadcrumbSep = sep
+	t.Cleanup(func() { breadcrumbSep = old })
+}
+
+func TestTitleLevels(t *testing.T) {
+	useBreadcrumbSep(t, "__")
+
+	tests := []struct {
+		name string
+		want []titleLevel
+	}{
+		{"Single", []titleLevel{{"Single", "Single"}}},
+		{"A__B", []titleLevel{{"A", "A"}, {"B", "A__B"}}},
+		{"A__B__C", []titleLevel{{"A", "A"}, {"B", "A__B"}, {"C", "A__B__C"}}},
+		{"__A__", []titleLevel{{"A", "__A"}}},
+		{"A____B", []titleLevel{{"A", "A"}, {"B", "A____B"}}},
+		{"one_B", []titleLevel{{"one_B", "one_B"}}},
+	}
+
+	for _, tt := range tests {
+		if got := titleLevels(tt.name); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("titleLevels(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTitleLevelsWithoutSeparator(t *testing.T) {
+	useBreadcrumbSep(t, "")
+
+	want := []titleLevel{{"A_B_C", "A_B_C"}}
+	if got := titleLevels("A_B_C"); !reflect.DeepEqual(got, want) {
+		t.Errorf("titleLevels = %v, want %v", got, want)
+	}
+	if got := breadcrumbs("A_B_C"); got != nil {
+		t.Errorf("breadcrumbs = %v, want none", got)
+	}
+}
+
+func TestBreadcrumbs(t *testing.T) {
+	useBreadcrumbSep(t, "_")
+
+	want := []breadcrumb{
+		{"Projects", "/view/Projects"},
+		{"Wiki", "/view/Projects_Wiki"},
+		{"Setup", ""},
+	}
+	if got := breadcrumbs("Projects_Wiki_Setup"); !reflect.DeepEqual(got, want) {
+		t.Errorf("breadcrumbs = %v, want %v", got, want)
+	}
+	if got := breadcrumbs("Projects"); got != nil {
+		t.Errorf("breadcrumbs of a single level = %v, want none", got)
+	}
+
+	// The crumbs of a page in another wiki stay in that wiki
+	useTempWiki(t)
+	useWikis(t, "docs")
+	if got := breadcrumbs("docs/Guide_Intro"); len(got) != 2 || got[0].URL != "/w/docs/view/Guide" {
+		t.Errorf("breadcrumbs in the docs wiki = %v", got)
+	}
+}
+
+func TestViewShowsBreadcrumbs(t *testing.T) {
+	useTempWiki(t)
+	useBreadcrumbSep(t, "_")
+	savePage(t, "Projects_Wiki_Setup", "body")
+
+	body := serve(httptest.NewRequest(http.MethodGet, "/view/Projects_Wiki_Setup", nil)).Body.String()
+	want := `<nav class="breadcrumbs"><a href="/view/Projects">Projects</a> / <a href="/view/Projects_Wiki">Wiki</a> / Setup</nav>`
+	if !strings.Contains(body, want) {
+		t.Errorf("the view doesn't show the breadcrumbs %s", want)
+	}
+}
+
+func TestTitleDepth(t *testing.T) {
+	useBreadcrumbSep(t, "_")
+	old := maxTitleDepth
+	maxTitleDepth = 3
+	defer func() { maxTitleDepth = old }()
+
+	tests := []struct {
+		title string
+		ok    bool
+	}{
+		{"Flat", true},
+		{"A_B", true},
+		{"A_B_C", true},
+		{"A_B_C_D", false},
+		{"A__B__C", true},
+		{"_A_B_C_", true},
+	}
+	for _, tt := range tests {
+		if err := validateTitle(tt.title); (err == nil) != tt.ok {
+			t.Errorf("validateTitle(%q) = %v, want ok %v", tt.title, err, tt.ok)
+		}
+	}
+
+	// Over-deep titles are refused with a 400
+	useTempWiki(t)
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/view/A_B_C_D", nil),
+		postForm("/save/A_B_C_D", nil),
+	} {
+		if w := serve(r); w.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: status = %d, want %d", r.Method, r.URL.Path, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	// 0 lifts the cap, and without a separator every title is one level
+	maxTitleDepth = 0
+	if err := validateTitle("A_B_C_D_E_F_G_H_I_J"); err != nil {
+		t.Errorf("without a cap: %v", err)
+	}
+	maxTitleDepth = 3
+	breadcrumbSep = ""
+	if err := validateTitle("A_B_C_D"); err != nil {
+		t.Errorf("without a separator: %v", err)
+	}
+}
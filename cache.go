@@ -0,0 +1,91 @@
+package main
+
+import "sync"
+
+// cacheEnabled turns the page cache on or off. With it off every load goes
+// straight to disk
+var cacheEnabled = true
+
+// pageCache keeps loaded pages in memory so that repeated views of the same
+// page don't have to go back to disk. Entries are replaced on save and
+// dropped on delete, so the cache never serves a page older than the last
+// write made through the wiki
+type pageCache struct {
+	mu    sync.RWMutex
+	pages map[string]*Page
+}
+
+// cache is the page cache shared by loadPage, save and deletePage
+var cache = &pageCache{pages: map[string]*Page{}}
+
+// get returns a copy of the cached page with the given title, if there is one.
+// Callers are free to modify the copy without affecting the cache
+func (c *pageCache) get(title string) (*Page, bool) {
+	if !cacheEnabled {
+		return nil, false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	p, ok := c.pages[title]
+	if !ok {
+		return nil, false
+	}
+	cp := *p
+	return &cp, true
+}
+
+// put stores a copy of p, replacing whatever was cached under its title
+func (c *pageCache) put(p *Page) {
+	if !cacheEnabled {
+		return
+	}
+
+	// Only the stored fields are cached, anything derived from them like the
+	// rendered HTML is recomputed by whoever needs it
+	cp := Page{Title: p.Title, Meta: p.Meta, Body: p.Body, ModTime: p.ModTime}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pages[p.Title] = &cp
+}
+
+// remove drops the page with the given title from the cache
+func (c *pageCache) remove(title string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pages, title)
+}
+
+// cacheStats describes what the cache is holding
+type cacheStats struct {
+	Enabled bool     `json:"enabled"`
+	Pages   int      `json:"pages"`
+	Bytes   int      `json:"bytes"`
+	Titles  []string `json:"titles"`
+}
+
+// stats returns the titles of the cached pages, sorted, and the total size of
+// their bodies
+func (c *pageCache) stats() cacheStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	s := cacheStats{Enabled: cacheEnabled, Pages: len(c.pages), Titles: sortedKeys(c.pages)}
+	for _, p := range c.pages {
+		s.Bytes += len(p.Body)
+	}
+	return s
+}
+
+// clear drops every page from the cache and returns their titles, sorted.
+// Anyone holding a copy from get keeps it, later loads go back to disk
+func (c *pageCache) clear() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	titles := sortedKeys(c.pages)
+	c.pages = map[string]*Page{}
+	return titles
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSaveIsVisibleOnNextView(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		enabled bool
+	}{{"cache on", true}, {"cache off", false}} {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempWiki(t)
+			old := cacheEnabled
+			cacheEnabled = tt.enabled
+			defer func() { cacheEnabled = old }()
+
+			for _, body := range []string{"first version", "second version"} {
+				if w := serve(postForm("/save/Fresh", url.Values{"body": {body}})); w.Code != http.StatusFound {
+					t.Fatalf("save: status = %d, want %d", w.Code, http.StatusFound)
+				}
+
+				w := serve(httptest.NewRequest(http.MethodGet, "/view/Fresh", nil))
+				if !strings.Contains(w.Body.String(), body) {
+					t.Errorf("view after saving %q doesn't show it", body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// caseInsensitive makes titles that only differ in case, like Home and home,
+// the same page. Titles are lowercased before they reach the store, and a
+// page keeps the casing it is shown with in a title front matter line
+var caseInsensitive = false
+
+// canonicalTitle returns the title a page is stored under. That is the title
+// itself unless titles are case-insensitive, when it is lowercased. Wiki
+// names are matched exactly either way, so only the page's own title changes
+func canonicalTitle(title string) string {
+	if !caseInsensitive {
+		return title
+	}
+	wiki, name := splitWiki(title)
+	return wikiTitle(wiki, strings.ToLower(name))
+}
+
+// Heading returns the title the page is shown with: the one its front matter
+// gives, if any, or its name within its wiki
+func (p Page) Heading() string {
+	if p.Meta.Title != "" {
+		return p.Meta.Title
+	}
+	return p.Name()
+}
+
+// redirectCanonical sends a GET or HEAD for a page under a title that isn't
+// its canonical one to the canonical URL, keeping the query, and reports
+// whether it did. Other methods are left alone, since redirecting them would
+// lose what was posted, and act on the canonical title instead. The editor of
+// a page that doesn't exist yet is passed the casing it was asked for, so it
+// can be kept as the page's heading
+func redirectCanonical(w http.ResponseWriter, r *http.Request, title string) bool {
+	canonical := canonicalTitle(title)
+	if canonical == title || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return false
+	}
+
+	action, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	if action == "edit" && !pageExists(r.Context(), canonical) {
+		_, name := splitWiki(title)
+		query.Set("heading", name)
+	}
+
+	target := pageURL(action, canonical)
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
+	return true
+}
+
+// queryHeading returns the heading a new page was asked for with, if it is
+// the same title as the page's in all but case
+func queryHeading(query url.Values, title string) string {
+	heading := query.Get("heading")
+	if _, name := splitWiki(title); !strings.EqualFold(heading, name) {
+		return ""
+	}
+	return heading
+}
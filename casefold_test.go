@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useCaseInsensitive makes titles case-insensitive for the length of the test
+func useCaseInsensitive(t *testing.T) {
+	t.Helper()
+
+	old := caseInsensitive
+	caseInsensitive = true
+	t.Cleanup(func() { caseInsensitive = old })
+}
+
+func TestCaseInsensitiveTitlesCollide(t *testing.T) {
+	useTempWiki(t)
+	useCaseInsensitive(t)
+
+	for _, title := range []string{"home", "Home", "HOME"} {
+		if w := serve(postForm("/save/"+title, url.Values{"body": {"saved as " + title}})); w.Code != http.StatusFound {
+			t.Fatalf("saving %s: status = %d", title, w.Code)
+		} else if got := w.Header().Get("Location"); got != "/view/home" {
+			t.Errorf("saving %s redirected to %q, want /view/home", title, got)
+		}
+	}
+
+	// All three saves went to the one page
+	files, _ := filepath.Glob(filepath.Join(dataDir, "*"+fileExt))
+	if len(files) != 1 || filepath.Base(files[0]) != "home"+fileExt {
+		t.Errorf("page files = %v, want just home%s", files, fileExt)
+	}
+	if w := serve(httptest.NewRequest(http.MethodGet, "/raw/home", nil)); w.Body.String() != "saved as HOME\n" {
+		t.Errorf("GET /raw/home = %q, want the last save", w.Body.String())
+	}
+}
+
+func TestCaseSensitiveByDefault(t *testing.T) {
+	useTempWiki(t)
+
+	for _, title := range []string{"home", "Home"} {
+		serve(postForm("/save/"+title, url.Values{"body": {"saved as " + title}}))
+	}
+	for _, title := range []string{"home", "Home"} {
+		if _, err := os.Stat(pageFile(title)); err != nil {
+			t.Errorf("%s wasn't saved as a page of its own: %v", title, err)
+		}
+	}
+	if w := serve(httptest.NewRequest(http.MethodGet, "/view/Home", nil)); w.Code != http.StatusOK {
+		t.Errorf("GET /view/Home: status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestCanonicalRedirect(t *testing.T) {
+	useTempWiki(t)
+	useCaseInsensitive(t)
+	savePage(t, "home", "---\ntitle: Home\n---\nbody\n")
+
+	tests := []struct {
+		path     string
+		location string
+	}{
+		{"/view/Home", "/view/home"},
+		{"/view/HOME?rev=1", "/view/home?rev=1"},
+		{"/raw/Home", "/raw/home"},
+		{"/edit/Home", "/edit/home"},
+		{"/edit/NewPage", "/edit/newpage?heading=NewPage"},
+	}
+	for _, tt := range tests {
+		w := serve(httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != tt.location {
+			t.Errorf("GET %s: %d to %q, want %d to %q", tt.path, w.Code, w.Header().Get("Location"), http.StatusMovedPermanently, tt.location)
+		}
+	}
+
+	// The canonical URL is served, with the casing kept for the heading
+	w := serve(httptest.NewRequest(http.MethodGet, "/view/home", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<h1>Home</h1>") {
+		t.Errorf("GET /view/home: %d, without the Home heading", w.Code)
+	}
+}
+
+func TestCaseInsensitiveLinks(t *testing.T) {
+	useTempWiki(t)
+	useCaseInsensitive(t)
+	savePage(t, "target", "here")
+
+	// A link in any casing finds the page, rather than offering to create it
+	html := string(renderMarkdown("", []byte("See [Target] and [TARGET].")))
+	if strings.Contains(html, `class="missing"`) {
+		t.Errorf("a link to an existing page is shown as missing: %s", html)
+	}
+	if strings.Count(html, `href="/view/target"`) != 2 {
+		t.Errorf("the links don't point at the canonical URL: %s", html)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// changeHooks are called with the title of every page the store changes,
+// once the change has been made: saves, deletes, restores and purges, and
+// both titles of a rename. Anything worked out from the pages and kept
+// around, like the tag index, registers a hook here to know when to throw it
+// away
+var changeHooks []func(title string)
+
+// wikiChanges records when a page of each wiki last changed. A response
+// worked out from more than one page, like a view listing the pages that
+// link to it, is only as fresh as the last change anywhere in its wiki. A
+// wiki nothing has changed in since startup counts as changed at startup,
+// since nothing says what happened while the process wasn't running
+var wikiChanges = struct {
+	mu      sync.Mutex
+	started time.Time
+	byWiki  map[string]time.Time
+}{started: time.Now(), byWiki: map[string]time.Time{}}
+
+// Notes the time of every change
+func init() {
+	changeHooks = append(changeHooks, func(title string) {
+		wiki, _ := splitWiki(title)
+		wikiChanges.mu.Lock()
+		defer wikiChanges.mu.Unlock()
+		wikiChanges.byWiki[wiki] = time.Now()
+	})
+}
+
+// wikiChanged returns when a page of the given wiki last changed
+func wikiChanged(wiki string) time.Time {
+	wikiChanges.mu.Lock()
+	defer wikiChanges.mu.Unlock()
+	if t, ok := wikiChanges.byWiki[wiki]; ok {
+		return t
+	}
+	return wikiChanges.started
+}
+
+// notifyingStore wraps a Store and runs changeHooks after each change that
+// succeeds
+type notifyingStore struct {
+	Store
+}
+
+// notify runs changeHooks for titles unless err says the change failed
+func notify(err error, titles ...string) error {
+	if err != nil {
+		return err
+	}
+	for _, title := range titles {
+		for _, hook := range changeHooks {
+			hook(title)
+		}
+	}
+	return nil
+}
+
+// Save saves the page to the wrapped Store
+func (s notifyingStore) Save(ctx context.Context, p *Page) error {
+	return notify(s.Store.Save(ctx, p), p.Title)
+}
+
+// Delete deletes the page from the wrapped Store
+func (s notifyingStore) Delete(ctx context.Context, title string) error {
+	return notify(s.Store.Delete(ctx, title), title)
+}
+
+// Restore restores the page in the wrapped Store
+func (s notifyingStore) Restore(ctx context.Context, title string) error {
+	return notify(s.Store.Restore(ctx, title), title)
+}
+
+// Purge purges the page from the wrapped Store
+func (s notifyingStore) Purge(ctx context.Context, title string) error {
+	return notify(s.Store.Purge(ctx, title), title)
+}
+
+// Rename renames the page in the wrapped Store
+func (s notifyingStore) Rename(ctx context.Context, oldTitle, newTitle string) error {
+	return notify(s.Store.Rename(ctx, oldTitle, newTitle), oldTitle, newTitle)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxConcurrent caps how many requests are handled at once, so a sudden
+// crowd can't start more disk reads and writes than the server copes with.
+// 0 means there is no cap
+var maxConcurrent = 0
+
+// queueTimeout is how long a request waits for a slot once maxConcurrent are
+// being handled before it is turned away. 0 turns it away straight off
+var queueTimeout time.Duration
+
+// limitConcurrency is middleware that lets at most maxConcurrent requests
+// through to next at a time, answering 503 Service Unavailable to any that
+// can't get a slot within queueTimeout. Health checks and metrics are never
+// held up, so a busy server isn't mistaken for a dead one, and event streams
+// aren't counted since they would hold a slot for as long as they are open
+func limitConcurrency(next http.Handler) http.Handler {
+	if maxConcurrent <= 0 {
+		return next
+	}
+
+	slots := make(chan struct{}, maxConcurrent)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unlimitedPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		select {
+		case slots <- struct{}{}:
+		default:
+			if !waitForSlot(r, slots) {
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "the server is busy, try again shortly", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		defer func() { <-slots }()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// waitForSlot waits up to queueTimeout for one of slots to free up and takes
+// it, reporting whether it did. A client that gives up in the meantime
+// doesn't get one either
+func waitForSlot(r *http.Request, slots chan struct{}) bool {
+	if queueTimeout <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(queueTimeout)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// unlimitedPath reports whether a request path skips limitConcurrency: the
+// health check, the metrics and the event streams of any wiki
+func unlimitedPath(path string) bool {
+	if path == "/healthz" || path == "/metrics" {
+		return true
+	}
+
+	// Event streams of other wikis are under /w/<name>/events/
+	if rest, ok := strings.CutPrefix(path, "/w/"); ok {
+		if _, after, found := strings.Cut(rest, "/"); found {
+			path = "/" + after
+		}
+	}
+	return strings.HasPrefix(path, "/events/")
+}
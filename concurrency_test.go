@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// useMaxConcurrent caps concurrent requests at n, queueing each for up to
+// timeout, for the length of the test
+func useMaxConcurrent(t *testing.T, n int, timeout time.Duration) {
+	t.Helper()
+
+	oldMax, oldTimeout := maxConcurrent, queueTimeout
+	maxConcurrent, queueTimeout = n, timeout
+	t.Cleanup(func() { maxConcurrent, queueTimeout = oldMax, oldTimeout })
+}
+
+// blockingHandler returns a handler that holds each request until release is
+// closed, sending on entered as each one starts
+func blockingHandler(entered chan<- struct{}, release <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	})
+}
+
+func TestLimitConcurrency(t *testing.T) {
+	useMaxConcurrent(t, 2, 0)
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	h := limitConcurrency(blockingHandler(entered, release))
+
+	// Fills every slot
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/view/Foo", nil))
+		}()
+		<-entered
+	}
+
+	// The next request is turned away
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/view/Foo", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("request past the cap: status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Errorf("request past the cap has no Retry-After")
+	}
+
+	// Health checks get through regardless
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	select {
+	case <-entered:
+	case <-time.After(time.Second):
+		t.Error("the health check was held up by the cap")
+	}
+
+	close(release)
+	wg.Wait()
+
+	// With the slots free again requests go through
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/view/Foo", nil))
+	select {
+	case <-entered:
+	case <-time.After(time.Second):
+		t.Error("a request was refused once the slots were free")
+	}
+}
+
+func TestLimitConcurrencyQueues(t *testing.T) {
+	useMaxConcurrent(t, 1, 5*time.Second)
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	h := limitConcurrency(blockingHandler(entered, release))
+
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/view/Foo", nil))
+	<-entered
+
+	// A second request waits for the slot instead of being turned away
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/view/Foo", nil))
+		done <- w.Code
+	}()
+	select {
+	case <-entered:
+		t.Fatal("the queued request ran while the slot was taken")
+	case code := <-done:
+		t.Fatalf("the queued request was answered %d straight away", code)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	<-entered
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("the queued request: status = %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestUnlimitedPath(t *testing.T) {
+	for path, want := range map[string]bool{
+		"/healthz":            true,
+		"/metrics":            true,
+		"/events/Foo":         true,
+		"/w/docs/events/Foo":  true,
+		"/view/Foo":           false,
+		"/w/docs/view/events": false,
+	} {
+		if got := unlimitedPath(path); got != want {
+			t.Errorf("unlimitedPath(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// Version returns a short hash of the page's source. The editor sends back
+// the version it started from, so a save can tell whether someone else has
+// changed the page in the meantime
+func (p Page) Version() string {
+	sum := sha256.Sum256(p.Source())
+	return hex.EncodeToString(sum[:8])
+}
+
+// editConflict returns the current version of the page being saved if it
+// isn't the one the editor was opened on. The editor sends an empty version
+// for a page that didn't exist yet. Forms without a version at all, like the
+// one restoring an old revision, mean to replace whatever is there and are
+// never in conflict
+func editConflict(r *http.Request, title string) (*Page, bool) {
+	version, ok := r.PostForm["version"]
+	if !ok {
+		return nil, false
+	}
+
+	current, err := store.Load(r.Context(), title)
+	if err != nil || current.Version() == version[0] {
+		return nil, false
+	}
+	return current, true
+}
+
+// renderEditForm shows the editor again with the page that was submitted,
+// for when a save didn't go through, answering with the given status
+func renderEditForm(w http.ResponseWriter, r *http.Request, p *Page, status int) {
+	p.New = !pageExists(r.Context(), p.Title)
+	p.Autosave = draftEnabled
+	p.CSRFToken = csrfToken(w, r)
+	renderTemplateStatus(w, status, "edit", *p)
+}
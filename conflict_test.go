@@ -0,0 +1,88 @@
+package main
+
+import (
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// editVersion opens the editor on the page and returns the version its form
+// would send back
+func editVersion(t *testing.T, path string) string {
+	t.Helper()
+
+	body := serve(httptest.NewRequest(http.MethodGet, path, nil)).Body.String()
+	m := regexp.MustCompile(`name="version" value="([^"]*)"`).FindStringSubmatch(body)
+	if m == nil {
+		t.Fatalf("the form at %s has no version field", path)
+	}
+	return m[1]
+}
+
+func TestConcurrentEditConflict(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Shared", "original\n")
+
+	// Two editors open the page, and the first saves
+	mine := editVersion(t, "/edit/Shared")
+	theirs := editVersion(t, "/edit/Shared")
+	if w := serve(postForm("/save/Shared", url.Values{"body": {"their change"}, "version": {theirs}})); w.Code != http.StatusFound {
+		t.Fatalf("the first save: status = %d, want %d", w.Code, http.StatusFound)
+	}
+
+	// The second save started from the old version, so is refused with both
+	// versions shown
+	w := serve(postForm("/save/Shared", url.Values{"body": {"my change"}, "version": {mine}}))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("the second save: status = %d, want %d", w.Code, http.StatusConflict)
+	}
+	body := html.UnescapeString(w.Body.String())
+	if !strings.Contains(body, "my change") || !strings.Contains(body, "their change") {
+		t.Errorf("the conflict form doesn't show both versions")
+	}
+	if p, _ := loadPage("Shared"); string(p.Body) != "their change\n" {
+		t.Errorf("the conflicting save overwrote the page with %q", p.Body)
+	}
+
+	// Saving the merge from the conflict form goes through
+	m := regexp.MustCompile(`name="version" value="([^"]*)"`).FindStringSubmatch(w.Body.String())
+	if m == nil {
+		t.Fatal("the conflict form has no version field")
+	}
+	if w := serve(postForm("/save/Shared", url.Values{"body": {"merged"}, "version": {m[1]}})); w.Code != http.StatusFound {
+		t.Fatalf("saving the merge: status = %d, want %d", w.Code, http.StatusFound)
+	}
+	if p, _ := loadPage("Shared"); string(p.Body) != "merged\n" {
+		t.Errorf("after the merge the page is %q", p.Body)
+	}
+}
+
+func TestConflictCreatingPage(t *testing.T) {
+	useTempWiki(t)
+
+	// Both editors open a page that doesn't exist yet
+	version := editVersion(t, "/edit/Fresh")
+	if version != "" {
+		t.Fatalf("a new page has version %q, want \"\"", version)
+	}
+	serve(postForm("/save/Fresh", url.Values{"body": {"first"}, "version": {version}}))
+
+	if w := serve(postForm("/save/Fresh", url.Values{"body": {"second"}, "version": {version}})); w.Code != http.StatusConflict {
+		t.Errorf("creating a page someone else just created: status = %d, want %d", w.Code, http.StatusConflict)
+	}
+}
+
+func TestSaveWithoutVersionReplaces(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Shared", "original\n")
+
+	// Forms that don't send a version, like restoring a revision, mean to
+	// replace the page
+	if w := serve(postForm("/save/Shared", url.Values{"body": {"replaced"}})); w.Code != http.StatusFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusFound)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsOrigins are the origins, like https://app.example.com, whose scripts
+// may call the API from the browser. "*" allows any origin. Empty means no
+// cross-origin requests are allowed, which is the browser's default
+var corsOrigins = map[string]bool{}
+
+// corsCredentials lets the allowed origins send credentials, such as the
+// Authorization header the API's writes need, along with their requests. It
+// can't be combined with the "*" origin
+var corsCredentials = false
+
+// corsMethods and corsHeaders are what a preflight request is told the API
+// accepts
+const (
+	corsMethods = "GET, PUT, DELETE"
+	corsHeaders = "Authorization, Content-Type"
+)
+
+// parseCORSOrigins sets corsOrigins from the comma separated list given to
+// -cors-origins
+func parseCORSOrigins(list string) {
+	for _, origin := range strings.Split(list, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			corsOrigins[strings.TrimSuffix(origin, "/")] = true
+		}
+	}
+}
+
+// allowCORS is middleware that adds the CORS headers to responses for
+// origins in corsOrigins and answers their preflight requests itself. The
+// origin is always echoed back rather than sending "*", and requests from
+// any other origin get no CORS headers at all, so the browser blocks them
+func allowCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// What is sent depends on the Origin, so caches have to keep the
+		// responses apart
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		if origin == "" || (!corsOrigins[origin] && !corsOrigins["*"]) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", origin)
+		if corsCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		// A preflight asks whether the real request may be sent, and never
+		// reaches the API itself
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", corsMethods)
+			h.Set("Access-Control-Allow-Headers", corsHeaders)
+			h.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
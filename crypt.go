@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// encryptedMagic starts every encrypted page file, so pages written before
+// encryption was turned on can still be told apart and read
+var encryptedMagic = []byte("wiki-aesgcm1:")
+
+// pageCipher encrypts pages as they are written and decrypts them as they
+// are read. It is nil, leaving pages in plain text, unless -encrypt-key is set
+var pageCipher cipher.AEAD
+
+// errWrongKey is returned when an encrypted page can't be decrypted with the
+// configured key
+var errWrongKey = errors.New("could not decrypt page, the -encrypt-key is wrong or the file is corrupt")
+
+// setEncryptKey turns on encryption with an AES key given as hex. It has to
+// be 16, 24 or 32 bytes long, picking AES-128, AES-192 or AES-256
+func setEncryptKey(hexKey string) error {
+	key, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return fmt.Errorf("key is not valid hex: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	pageCipher, err = cipher.NewGCM(block)
+	return err
+}
+
+// sealPage encrypts a page's source for writing to disk, as the magic prefix
+// followed by a random nonce and the ciphertext. Without a key the source is
+// returned unchanged
+func sealPage(src []byte) ([]byte, error) {
+	if pageCipher == nil {
+		return src, nil
+	}
+
+	nonce := make([]byte, pageCipher.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append([]byte{}, encryptedMagic...)
+	out = append(out, nonce...)
+	return pageCipher.Seal(out, nonce, src, nil), nil
+}
+
+// openPage reverses sealPage. Files without the magic prefix are plain text
+// and returned as they are, so turning encryption on doesn't lock out
+// existing pages; they are encrypted the next time they are saved
+func openPage(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, encryptedMagic) {
+		return data, nil
+	}
+	if pageCipher == nil {
+		return nil, errors.New("page is encrypted, start the wiki with its -encrypt-key to read it")
+	}
+
+	data = data[len(encryptedMagic):]
+	if len(data) < pageCipher.NonceSize() {
+		return nil, errWrongKey
+	}
+
+	nonce, sealed := data[:pageCipher.NonceSize()], data[pageCipher.NonceSize():]
+	src, err := pageCipher.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, errWrongKey
+	}
+	return src, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// useEncryptKey encrypts pages with the given hex key for the length of the
+// test
+func useEncryptKey(t *testing.T, hexKey string) {
+	t.Helper()
+
+	old := pageCipher
+	if err := setEncryptKey(hexKey); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pageCipher = old })
+}
+
+const (
+	testKey  = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	otherKey = "ffeeddccbbaa99887766554433221100ffeeddccbbaa99887766554433221100"
+)
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	useTempWiki(t)
+	useEncryptKey(t, testKey)
+
+	if err := (&Page{Title: "Secret", Body: []byte("sensitive notes")}).save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing readable reaches the disk
+	data, err := os.ReadFile(pageFile("Secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, encryptedMagic) || bytes.Contains(data, []byte("sensitive")) {
+		t.Errorf("page file isn't encrypted: %q", data)
+	}
+
+	cache.clear()
+	p, err := loadPage("Secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p.Body) != "sensitive notes" {
+		t.Errorf("decrypted body = %q", p.Body)
+	}
+}
+
+func TestEncryptionWrongKey(t *testing.T) {
+	useTempWiki(t)
+	useEncryptKey(t, testKey)
+	if err := (&Page{Title: "Secret", Body: []byte("sensitive notes")}).save(); err != nil {
+		t.Fatal(err)
+	}
+
+	useEncryptKey(t, otherKey)
+	cache.clear()
+	if _, err := loadPage("Secret"); !errors.Is(err, errWrongKey) {
+		t.Errorf("loading with the wrong key: err = %v, want errWrongKey", err)
+	}
+
+	// Without any key the error says a key is needed
+	pageCipher = nil
+	if _, err := loadPage("Secret"); err == nil || !strings.Contains(err.Error(), "-encrypt-key") {
+		t.Errorf("loading without a key: err = %v", err)
+	}
+}
+
+func TestPlainPagesReadableWithKey(t *testing.T) {
+	useTempWiki(t)
+	if err := (&Page{Title: "Old", Body: []byte("written in the clear")}).save(); err != nil {
+		t.Fatal(err)
+	}
+
+	useEncryptKey(t, testKey)
+	cache.clear()
+	p, err := loadPage("Old")
+	if err != nil || string(p.Body) != "written in the clear" {
+		t.Errorf("loadPage = %v, %v", p, err)
+	}
+}
+
+func TestSetEncryptKeyRejectsBadKeys(t *testing.T) {
+	old := pageCipher
+	defer func() { pageCipher = old }()
+
+	for _, key := range []string{"not hex", "0011", strings.Repeat("00", 20)} {
+		if err := setEncryptKey(key); err == nil {
+			t.Errorf("setEncryptKey(%q) succeeded", key)
+		}
+	}
+}
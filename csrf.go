@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// csrfCookie is the name of the cookie holding a client's CSRF token, and
+// csrfField the name of the hidden form field it has to be echoed back in
+const (
+	csrfCookie = "csrf_token"
+	csrfField  = "csrf_token"
+)
+
+// csrfKey signs CSRF tokens so that only ones issued by this server are
+// accepted. It is generated fresh on every start, which just means forms
+// loaded before a restart have to be reloaded
+var csrfKey = newCSRFKey()
+
+// newCSRFKey returns a random key for signing CSRF tokens
+func newCSRFKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic("could not generate CSRF key: " + err.Error())
+	}
+	return key
+}
+
+// signCSRF returns the signature of a token's random part
+func signCSRF(nonce string) string {
+	mac := hmac.New(sha256.New, csrfKey)
+	mac.Write([]byte(nonce))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// validCSRFToken reports whether token has the "<nonce>.<signature>" form and
+// was signed with csrfKey
+func validCSRFToken(token string) bool {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	return hmac.Equal([]byte(parts[1]), []byte(signCSRF(parts[0])))
+}
+
+// csrfToken returns the CSRF token for the client making the request, issuing
+// a new one in a cookie if the client doesn't have a valid one yet. The
+// returned token goes in a hidden field of any form that changes pages
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(csrfCookie); err == nil && validCSRFToken(c.Value) {
+		return c.Value
+	}
+
+	raw := make([]byte, 18)
+	if _, err := rand.Read(raw); err != nil {
+		panic("could not generate CSRF token: " + err.Error())
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(raw)
+	token := nonce + "." + signCSRF(nonce)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     basePath + "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token
+}
+
+// checkCSRF reports whether a submitted form carries the same signed token as
+// the client's CSRF cookie. Another site can make the browser send the cookie
+// but can't read it to fill in the form field, so a forged request fails
+func checkCSRF(r *http.Request) bool {
+	c, err := r.Cookie(csrfCookie)
+	if err != nil || !validCSRFToken(c.Value) {
+		return false
+	}
+
+	submitted := r.PostFormValue(csrfField)
+	return subtle.ConstantTimeCompare([]byte(submitted), []byte(c.Value)) == 1
+}
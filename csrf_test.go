@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSaveWithoutValidCSRFToken(t *testing.T) {
+	useTempWiki(t)
+
+	tests := []struct {
+		name   string
+		cookie string
+		field  string
+	}{
+		{"no token", "", ""},
+		{"field without cookie", "", testToken()},
+		{"cookie without field", testToken(), ""},
+		{"tokens differ", testToken(), "other." + signCSRF("other")},
+		{"unsigned token", "forged.sig", "forged.sig"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"body": {"forged"}}
+			if tt.field != "" {
+				form.Set(csrfField, tt.field)
+			}
+			r := httptest.NewRequest(http.MethodPost, "/save/Target", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: csrfCookie, Value: tt.cookie})
+			}
+
+			if w := serve(r); w.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+			}
+			if _, err := os.Stat(pageFile("Target")); !os.IsNotExist(err) {
+				t.Errorf("the page was saved anyway")
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+)
+
+// maxDiffCells caps the size of the table the line diff fills in, the product
+// of the number of lines that differ on each side. Anything bigger is shown
+// as every old line removed and every new line added, so a huge page can't
+// make a diff take minutes
+const maxDiffCells = 4_000_000
+
+// diffLine is a single line of a diff. Op is "add", "del" or "same"
+type diffLine struct {
+	Op   string
+	Text string
+}
+
+// diffPage is the data rendered by diff.html
+type diffPage struct {
+	Title    string
+	From, To Revision
+	Lines    []diffLine
+}
+
+// diffHandler compares two versions of a page, given as revision IDs in the
+// from and to query parameters, and shows which lines were added or removed.
+// Leaving out to compares against the current version. An ID that isn't a
+// revision of the page is a 404
+func diffHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if !checkPageAccess(w, r, title) {
+		return
+	}
+
+	from, ok := diffSide(title, r.URL.Query().Get("from"))
+	if !ok {
+		notFound(w, r)
+		return
+	}
+
+	var to *Page
+	if id := r.URL.Query().Get("to"); id != "" {
+		if to, ok = diffSide(title, id); !ok {
+			notFound(w, r)
+			return
+		}
+	} else {
+		p, err := store.Load(r.Context(), title)
+		if err != nil {
+			notFound(w, r)
+			return
+		}
+		to = p
+	}
+
+	// Either version may have expired, or been private at the time
+	if !checkNotExpired(w, from) || !checkNotExpired(w, to) {
+		return
+	}
+	if !canView(r, from) || !canView(r, to) {
+		requestAuth(w)
+		return
+	}
+
+	renderTemplate(w, "diff", diffPage{
+		Title: title,
+		From:  Revision{Title: title, ID: from.ModTime.UnixNano(), Time: from.ModTime},
+		To:    Revision{Title: title, ID: to.ModTime.UnixNano(), Time: to.ModTime},
+		Lines: diffLines(splitLines(from.Source()), splitLines(to.Source())),
+	})
+}
+
+// diffSide loads the revision of a page whose ID is given as a string
+func diffSide(title, rev string) (*Page, bool) {
+	id, err := strconv.ParseInt(rev, 10, 64)
+	if err != nil {
+		return nil, false
+	}
+
+	p, err := loadRevision(title, id)
+	if err != nil {
+		return nil, false
+	}
+	return p, true
+}
+
+// splitLines breaks a page body into lines without their line endings
+func splitLines(body []byte) []string {
+	body = bytes.TrimSuffix(body, []byte("\n"))
+	if len(body) == 0 {
+		return nil
+	}
+
+	var lines []string
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		lines = append(lines, string(bytes.TrimSuffix(line, []byte("\r"))))
+	}
+	return lines
+}
+
+// diffLines returns the edits that turn a into b, using the longest common
+// subsequence of their lines. Lines shared at the start and end are matched
+// up first so the table only covers the part that changed
+func diffLines(a, b []string) []diffLine {
+	var head, tail []diffLine
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		head = append(head, diffLine{Op: "same", Text: a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		tail = append([]diffLine{{Op: "same", Text: a[len(a)-1]}}, tail...)
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	lines := head
+	if len(a)*len(b) > maxDiffCells {
+		for _, line := range a {
+			lines = append(lines, diffLine{Op: "del", Text: line})
+		}
+		for _, line := range b {
+			lines = append(lines, diffLine{Op: "add", Text: line})
+		}
+		return append(lines, tail...)
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and
+	// b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	// Walks the table from the start, keeping shared lines and otherwise
+	// taking whichever step keeps the longer subsequence
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, diffLine{Op: "same", Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, diffLine{Op: "del", Text: a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{Op: "add", Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, diffLine{Op: "del", Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, diffLine{Op: "add", Text: b[j]})
+	}
+
+	return append(lines, tail...)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// draftEnabled turns autosaving drafts from the editor on or off
+var draftEnabled = true
+
+// draftDir returns the directory unsaved drafts of the given wiki are kept
+// in, apart from the published pages
+func draftDir(wiki string) string {
+	return filepath.Join(wikiDir(wiki), "drafts")
+}
+
+// draftFile returns the path of the draft of the page with the given title
+func draftFile(title string) string {
+	wiki, name := splitWiki(title)
+	return filepath.Join(draftDir(wiki), name+fileExt)
+}
+
+// saveDraft writes the source of p as its page's draft, replacing any earlier
+// draft. The write goes through a temporary file like a real save does, so an
+// autosave cut short never leaves half a draft behind
+func saveDraft(p *Page) error {
+	if err := checkFileTitle(p.Title); err != nil {
+		return err
+	}
+	wiki, name := splitWiki(p.Title)
+	if err := os.MkdirAll(draftDir(wiki), 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(draftDir(wiki), "."+name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	data, err := sealPage(p.Source())
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), draftFile(p.Title))
+}
+
+// loadDraft reads the draft of the page with the given title. ModTime is when
+// the draft was last saved
+func loadDraft(title string) (*Page, error) {
+	if err := checkFileTitle(title); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(draftFile(title))
+	if err != nil {
+		return nil, err
+	}
+	src, err := openPage(data)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(draftFile(title))
+	if err != nil {
+		return nil, err
+	}
+
+	p := pageFromSource(title, src)
+	p.ModTime = info.ModTime()
+	return p, nil
+}
+
+// deleteDraft removes the draft of the page with the given title. Having no
+// draft to remove isn't an error
+func deleteDraft(title string) error {
+	if err := checkFileTitle(title); err != nil {
+		return err
+	}
+
+	err := os.Remove(draftFile(title))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// renameDraft moves the draft of the page called oldTitle to newTitle. A
+// draft newTitle already has is never overwritten, the error matches
+// os.ErrExist instead
+func renameDraft(oldTitle, newTitle string) error {
+	if err := checkFileTitle(oldTitle); err != nil {
+		return err
+	}
+	if err := checkFileTitle(newTitle); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(draftFile(oldTitle)); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if _, err := os.Stat(draftFile(newTitle)); err == nil {
+		return fmt.Errorf("draft %q: %w", newTitle, os.ErrExist)
+	}
+	wiki, _ := splitWiki(newTitle)
+	if err := os.MkdirAll(draftDir(wiki), 0700); err != nil {
+		return err
+	}
+	return os.Rename(draftFile(oldTitle), draftFile(newTitle))
+}
+
+// draftStore wraps a Store and keeps drafts with the pages they belong to.
+// Drafts are files whichever store holds the pages, so a draft follows its
+// page when it is renamed and goes when it is deleted or purged, rather than
+// being offered to the next page given the old title
+type draftStore struct {
+	Store
+}
+
+// Delete deletes the page from the wrapped Store and drops its draft
+func (s draftStore) Delete(ctx context.Context, title string) error {
+	if err := s.Store.Delete(ctx, title); err != nil {
+		return err
+	}
+	if err := deleteDraft(title); err != nil {
+		log.Printf("could not remove the draft of %s: %v", title, err)
+	}
+	return nil
+}
+
+// Purge purges the page from the wrapped Store and drops any draft left
+// under its title
+func (s draftStore) Purge(ctx context.Context, title string) error {
+	if err := s.Store.Purge(ctx, title); err != nil {
+		return err
+	}
+	if err := deleteDraft(title); err != nil {
+		log.Printf("could not remove the draft of %s: %v", title, err)
+	}
+	return nil
+}
+
+// Rename renames the page in the wrapped Store and moves its draft along
+func (s draftStore) Rename(ctx context.Context, oldTitle, newTitle string) error {
+	if err := s.Store.Rename(ctx, oldTitle, newTitle); err != nil {
+		return err
+	}
+	if err := renameDraft(oldTitle, newTitle); err != nil {
+		log.Printf("could not move the draft of %s to %s: %v", oldTitle, newTitle, err)
+	}
+	return nil
+}
+
+// draftHandler stores what is in the editor as the page's draft. The editor
+// posts to it every so often while the page is being edited, and answers
+// 204 so nothing on the page changes
+func draftHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if !draftEnabled {
+		notFound(w, r)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+	if err := r.ParseForm(); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("page body cannot be larger than %d bytes", maxBodySize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !checkCSRF(r) {
+		http.Error(w, "invalid or missing CSRF token, reload the page and try again", http.StatusForbidden)
+		return
+	}
+
+	p := pageFromSource(title, normalizeBody(fixUTF8([]byte(r.FormValue("body")))))
+	if err := validatePage(p); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := saveDraft(p); err != nil {
+		serverError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
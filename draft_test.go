@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDraftSaveLoadClear(t *testing.T) {
+	useTempWiki(t)
+
+	if err := saveDraft(&Page{Title: "Essay", Body: []byte("half written")}); err != nil {
+		t.Fatal(err)
+	}
+	d, err := loadDraft("Essay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(d.Body) != "half written" || d.ModTime.IsZero() {
+		t.Errorf("loaded draft = %q at %v", d.Body, d.ModTime)
+	}
+
+	// Drafts are kept apart from the published pages
+	if _, err := os.Stat(pageFile("Essay")); !os.IsNotExist(err) {
+		t.Error("saving a draft published the page")
+	}
+	if titles, _ := listPages(""); len(titles) != 0 {
+		t.Errorf("drafts are listed as pages: %v", titles)
+	}
+
+	if err := deleteDraft("Essay"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadDraft("Essay"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("loadDraft after deleteDraft: err = %v, want os.ErrNotExist", err)
+	}
+	if err := deleteDraft("Essay"); err != nil {
+		t.Errorf("deleting a missing draft: %v", err)
+	}
+}
+
+func TestDraftFlow(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Essay", "published version\n")
+
+	if w := serve(postForm("/draft/Essay", url.Values{"body": {"draft version"}})); w.Code != http.StatusNoContent {
+		t.Fatalf("draft: status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+
+	// The editor offers the draft, and loads it when asked
+	body := serve(httptest.NewRequest(http.MethodGet, "/edit/Essay", nil)).Body.String()
+	if !strings.Contains(body, "published version") || !strings.Contains(body, "Restore draft?") {
+		t.Error("the editor doesn't offer the draft over the published version")
+	}
+	if body := serve(httptest.NewRequest(http.MethodGet, "/edit/Essay?draft=1", nil)).Body.String(); !strings.Contains(body, "draft version") {
+		t.Error("?draft=1 doesn't load the draft into the editor")
+	}
+
+	// Publishing clears it
+	if w := serve(postForm("/save/Essay", url.Values{"body": {"draft version"}})); w.Code != http.StatusFound {
+		t.Fatalf("save: status = %d, want %d", w.Code, http.StatusFound)
+	}
+	if _, err := loadDraft("Essay"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the draft is still there after saving: %v", err)
+	}
+}
+
+func TestDraftFollowsRename(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Essay", "published version\n")
+	if err := saveDraft(pageFromSource("Essay", []byte("draft version\n"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := serve(postForm("/rename/Essay", url.Values{"newtitle": {"Article"}})); w.Code != http.StatusFound {
+		t.Fatalf("rename: status = %d, want %d", w.Code, http.StatusFound)
+	}
+
+	if _, err := loadDraft("Essay"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the draft stayed under the old title: %v", err)
+	}
+	d, err := loadDraft("Article")
+	if err != nil {
+		t.Fatalf("the draft didn't move to the new title: %v", err)
+	}
+	if string(d.Body) != "draft version\n" {
+		t.Errorf("moved draft = %q, want %q", d.Body, "draft version\n")
+	}
+}
+
+func TestDraftGoesWithDeletedPage(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Essay", "published version\n")
+	if err := saveDraft(pageFromSource("Essay", []byte("draft version\n"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := serve(postForm("/delete/Essay", nil)); w.Code != http.StatusFound {
+		t.Fatalf("delete: status = %d, want %d", w.Code, http.StatusFound)
+	}
+	if _, err := loadDraft("Essay"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the draft is still there after deleting: %v", err)
+	}
+
+	// A new page given the same title isn't offered the old page's draft
+	body := serve(httptest.NewRequest(http.MethodGet, "/edit/Essay", nil)).Body.String()
+	if strings.Contains(body, "Restore draft?") {
+		t.Error("the editor of a new page offers the deleted page's draft")
+	}
+
+	// Purging drops any draft left under the title as well
+	if err := saveDraft(pageFromSource("Essay", []byte("another draft\n"))); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(postForm("/purge/Essay", nil)); w.Code != http.StatusFound {
+		t.Fatalf("purge: status = %d, want %d", w.Code, http.StatusFound)
+	}
+	if _, err := loadDraft("Essay"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the draft is still there after purging: %v", err)
+	}
+}
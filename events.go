@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// eventsPing is how often an idle event stream is sent a comment, so proxies
+// don't close it for being quiet
+const eventsPing = 30 * time.Second
+
+// pageEvents tells the clients watching a page when it changes. Each client
+// gets a channel of its own, kept under the title of the page it watches
+type pageEvents struct {
+	mu   sync.Mutex
+	subs map[string]map[chan struct{}]bool
+
+	// done is closed when the server shuts down, ending every stream so
+	// they don't hold up the shutdown
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// events is where changes to pages are published for /events
+var events = &pageEvents{subs: map[string]map[chan struct{}]bool{}, done: make(chan struct{})}
+
+// Publishes every change the store makes
+func init() {
+	changeHooks = append(changeHooks, events.publish)
+}
+
+// subscribe returns a channel that receives a value after each change to the
+// page with the given title, and a function that must be called to stop
+// watching it
+func (e *pageEvents) subscribe(title string) (<-chan struct{}, func()) {
+	// One pending change is enough, however many happened since the client
+	// was last told, so a slow client never holds up a save
+	ch := make(chan struct{}, 1)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.subs[title] == nil {
+		e.subs[title] = map[chan struct{}]bool{}
+	}
+	e.subs[title][ch] = true
+
+	return ch, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		delete(e.subs[title], ch)
+		if len(e.subs[title]) == 0 {
+			delete(e.subs, title)
+		}
+	}
+}
+
+// publish tells everyone watching the page with the given title that it has
+// changed
+func (e *pageEvents) publish(title string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for ch := range e.subs[title] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// stop ends every event stream, now and from then on
+func (e *pageEvents) stop() {
+	e.stopOnce.Do(func() { close(e.done) })
+}
+
+// eventsHandler streams server-sent events about the page with the given
+// title. A "change" event is sent whenever the page is saved, deleted or
+// renamed, so a view of it can reload. The stream lasts until the client
+// goes away or the server shuts down
+func eventsHandler(w http.ResponseWriter, r *http.Request, title string) {
+	// When a private page changes is as private as the page itself
+	if !checkPageAccess(w, r, title) {
+		return
+	}
+
+	// The stream outlives the server's write timeout, and every event has to
+	// reach the client as soon as it's written
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	changes, unsubscribe := events.subscribe(title)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	_, name := splitWiki(title)
+	ping := time.NewTicker(eventsPing)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-events.done:
+			return
+		case <-changes:
+			fmt.Fprintf(w, "event: change\ndata: %s\n\n", name)
+		case <-ping.C:
+			io.WriteString(w, ": ping\n\n")
+		}
+
+		// A failed flush means the client is gone
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSavePublishesEvent(t *testing.T) {
+	useTempWiki(t)
+
+	changes, unsubscribe := events.subscribe("Watched")
+	defer unsubscribe()
+	others, unsubscribeOthers := events.subscribe("Other")
+	defer unsubscribeOthers()
+
+	serve(postForm("/save/Watched", url.Values{"body": {"changed"}}))
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatal("no event after saving the watched page")
+	}
+	select {
+	case <-others:
+		t.Error("saving one page told the watchers of another")
+	default:
+	}
+
+	// Changes pile up into one pending event rather than blocking saves
+	savePage(t, "Watched", "again")
+	savePage(t, "Watched", "and again")
+	<-changes
+	select {
+	case <-changes:
+		t.Error("more than one event was left pending")
+	default:
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	_, unsubscribe := events.subscribe("Gone")
+	unsubscribe()
+
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	if _, ok := events.subs["Gone"]; ok {
+		t.Error("the title is still watched after its only watcher left")
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Live", "before")
+
+	srv := httptest.NewServer(newHandler("static"))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events/Live", nil)
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	// The response headers are only sent once the handler has subscribed
+	savePage(t, "Live", "after")
+
+	lines := bufio.NewScanner(resp.Body)
+	var got []string
+	for lines.Scan() && lines.Text() != "" {
+		got = append(got, lines.Text())
+	}
+	if want := "event: change\ndata: Live"; strings.Join(got, "\n") != want {
+		t.Errorf("event = %q, want %q", strings.Join(got, "\n"), want)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// expiryInterval is how often the janitor looks for expired pages to delete.
+// 0 turns it off, leaving expired pages in place though still unviewable
+var expiryInterval = time.Minute
+
+// Expired reports whether the page's front matter gave it an expiry time
+// that has passed by now
+func (p Page) Expired(now time.Time) bool {
+	return !p.Meta.Expires.IsZero() && !now.Before(p.Meta.Expires)
+}
+
+// checkNotExpired answers 410 if the page has expired, since an expired page
+// is gone for good even before the janitor gets to it
+func checkNotExpired(w http.ResponseWriter, p *Page) bool {
+	if p.Expired(time.Now()) {
+		http.Error(w, "this page has expired", http.StatusGone)
+		return false
+	}
+	return true
+}
+
+// runExpiryJanitor deletes expired pages every expiryInterval until ctx is
+// cancelled. Deleted pages go to the trash like any other, so one that
+// expired too soon can still be restored, after moving its expiry back
+func runExpiryJanitor(ctx context.Context) {
+	if expiryInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// A read-only wiki is frozen, for a backup say, so nothing is moved
+		// until it can be changed again
+		if readOnly.Load() {
+			continue
+		}
+
+		// Every wiki is swept, the main one included
+		for _, wiki := range everyWiki() {
+			deleteExpired(context.WithValue(ctx, wikiKey{}, wiki), time.Now())
+		}
+	}
+}
+
+// deleteExpired moves every page of the context's wiki that has expired by
+// now to the trash
+func deleteExpired(ctx context.Context, now time.Time) {
+	titles, err := store.List(ctx)
+	if err != nil {
+		log.Printf("expiry: %v", err)
+		return
+	}
+
+	for _, title := range titles {
+		p, err := store.Load(ctx, title)
+		if err != nil || !p.Expired(now) {
+			continue
+		}
+
+		// Someone may have beaten the janitor to it
+		if err := store.Delete(ctx, title); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("expiry: could not delete %s: %v", title, err)
+			continue
+		}
+		log.Printf("expiry: deleted %s, which expired at %s", title, p.Meta.Expires.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// expiredSource is the source of a page that expired an hour ago
+func expiredSource(body string) string {
+	return "---\nexpires: " + time.Now().Add(-time.Hour).UTC().Format(time.RFC3339) + "\n---\n" + body
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		expires time.Time
+		want    bool
+	}{
+		{time.Time{}, false},
+		{now.Add(time.Hour), false},
+		{now, true},
+		{now.Add(-time.Hour), true},
+	}
+	for _, tt := range tests {
+		p := Page{Meta: pageMeta{Expires: tt.expires}}
+		if got := p.Expired(now); got != tt.want {
+			t.Errorf("Expired with expiry %v = %v, want %v", tt.expires, got, tt.want)
+		}
+	}
+}
+
+func TestExpiredPageIsGone(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Old", expiredSource("stale unicorn\n"))
+	savePage(t, "Fresh", "fresh unicorn\n")
+
+	for _, path := range []string{"/view/Old", "/raw/Old", "/history/Old"} {
+		if w := serve(httptest.NewRequest(http.MethodGet, path, nil)); w.Code != http.StatusGone {
+			t.Errorf("GET %s: status = %d, want %d", path, w.Code, http.StatusGone)
+		}
+	}
+
+	w := serve(httptest.NewRequest(http.MethodGet, "/api/pages/Old", nil))
+	var resp map[string]string
+	if w.Code != http.StatusGone || json.Unmarshal(w.Body.Bytes(), &resp) != nil || resp["error"] == "" {
+		t.Errorf("GET /api/pages/Old: %d %s, want a 410 JSON error", w.Code, w.Body.String())
+	}
+
+	// Nor is it listed anywhere the fresh page is
+	for _, path := range []string{"/search?q=unicorn", "/recent", "/sitemap.xml"} {
+		body := serve(httptest.NewRequest(http.MethodGet, path, nil)).Body.String()
+		if !strings.Contains(body, "Fresh") {
+			t.Errorf("GET %s doesn't list the fresh page", path)
+		}
+		if strings.Contains(body, "Old") {
+			t.Errorf("GET %s lists the expired page", path)
+		}
+	}
+}
+
+func TestDeleteExpired(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Old", expiredSource("stale\n"))
+	savePage(t, "Later", "---\nexpires: "+time.Now().Add(time.Hour).UTC().Format(time.RFC3339)+"\n---\nlater\n")
+	savePage(t, "Forever", "forever\n")
+
+	deleteExpired(context.Background(), time.Now())
+
+	if pageExists(context.Background(), "Old") {
+		t.Error("the expired page wasn't deleted")
+	}
+	if _, err := os.Stat(trashFile("Old")); err != nil {
+		t.Errorf("the expired page isn't in the trash: %v", err)
+	}
+	for _, title := range []string{"Later", "Forever"} {
+		if !pageExists(context.Background(), title) {
+			t.Errorf("%s was deleted before expiring", title)
+		}
+	}
+}
+
+func TestExpiryJanitorWaitsWhileReadOnly(t *testing.T) {
+	useTempWiki(t)
+	useReadOnly(t)
+	savePage(t, "Old", expiredSource("stale\n"))
+
+	old := expiryInterval
+	expiryInterval = time.Millisecond
+	defer func() { expiryInterval = old }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runExpiryJanitor(ctx)
+	}()
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	// Plenty of sweeps go by without the frozen wiki being touched
+	time.Sleep(50 * time.Millisecond)
+	if !pageExists(context.Background(), "Old") {
+		t.Fatal("the janitor deleted a page while the wiki was read-only")
+	}
+
+	// Once writable again the next sweep deletes it
+	readOnly.Store(false)
+	deadline := time.Now().Add(5 * time.Second)
+	for pageExists(context.Background(), "Old") {
+		if time.Now().After(deadline) {
+			t.Fatal("the janitor didn't delete the expired page once the wiki was writable")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
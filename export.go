@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// exportingStatic is set while -export-static writes the wiki out as plain
+// files. Links between pages then point at the exported files instead of the
+// server's routes, and the templates leave out everything that needs the
+// server, like the edit links and forms
+var exportingStatic = false
+
+// isExporting reports whether the wiki is being exported, for the templates
+func isExporting() bool {
+	return exportingStatic
+}
+
+// staticPath returns the URL of a file under /static/, which is under
+// basePath like every other route. An export copies the files next to the
+// pages, so it links to them relatively
+func staticPath(name string) string {
+	if exportingStatic {
+		return "static/" + name
+	}
+	return basePath + "/static/" + name
+}
+
+// renderFile renders one of the templates with the given data into a file
+func renderFile(path, pageName string, data interface{}) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := render(f, pageName, data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// exportStatic writes every page of the main wiki into dir as
+// <title>.html, rendered just as the view shows it, along with an index.html
+// listing them and a copy of the static assets from staticDir. Private pages
+// are left out, since whoever reads the export can't log in
+func exportStatic(dir, staticDir string) error {
+	exportingStatic = true
+	ctx := context.Background()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	titles, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	var index indexPage
+	for _, title := range titles {
+		p, err := store.Load(ctx, title)
+		if err != nil {
+			return err
+		}
+		if p.Meta.Private || p.Expired(time.Now()) {
+			continue
+		}
+
+		p.HTML, p.TOC = renderMarkdownTOC(p.Wiki(), p.Body)
+		p.Words, p.Chars = pageStats(p)
+		p.Breadcrumbs = breadcrumbs(title)
+		if err := renderFile(filepath.Join(dir, title+".html"), "view", *p); err != nil {
+			return err
+		}
+		index.Titles = append(index.Titles, indexEntry{Title: title})
+	}
+
+	index.Total, index.Page, index.Pages = len(index.Titles), 1, 1
+	if err := renderFile(filepath.Join(dir, "index.html"), "list", index); err != nil {
+		return err
+	}
+	return copyDir(filepath.Join(dir, "static"), staticDir)
+}
+
+// copyDir copies every file under src into dst, keeping the layout of the
+// directories
+func copyDir(dst, src string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dst, rel), data, 0644)
+	})
+}
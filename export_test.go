@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportStatic(t *testing.T) {
+	useTempWiki(t)
+	defer func() { exportingStatic = false }()
+
+	savePage(t, "Home", "# Welcome\n\nSee [Other].\n")
+	savePage(t, "Other", "*other* page\n")
+	savePage(t, "Secret", "---\nprivate: true\n---\nsecret\n")
+
+	dir := filepath.Join(t.TempDir(), "site")
+	if err := exportStatic(dir, "static"); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(name string) string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("the export has no %s: %v", name, err)
+		}
+		return string(data)
+	}
+
+	// Pages are rendered as the view shows them, linking to each other's files
+	home := read("Home.html")
+	if !strings.Contains(home, "Welcome</h1>") {
+		t.Errorf("Home.html doesn't have the rendered heading")
+	}
+	if !strings.Contains(home, `href="Other.html"`) {
+		t.Errorf("Home.html doesn't link to Other.html")
+	}
+	if strings.Contains(home, "/edit/") || strings.Contains(home, "csrf_token") {
+		t.Errorf("Home.html has links or forms that need the server")
+	}
+	if !strings.Contains(read("Other.html"), "<em>other</em>") {
+		t.Errorf("Other.html doesn't have the rendered body")
+	}
+
+	// The index lists the exported pages, and the assets are copied
+	index := read("index.html")
+	for _, name := range []string{"Home.html", "Other.html"} {
+		if !strings.Contains(index, `href="`+name+`"`) {
+			t.Errorf("index.html doesn't link to %s", name)
+		}
+	}
+	if read("static/style.css") == "" {
+		t.Errorf("static/style.css is empty")
+	}
+
+	// Private pages are left out
+	if _, err := os.Stat(filepath.Join(dir, "Secret.html")); !os.IsNotExist(err) {
+		t.Errorf("the private page was exported")
+	}
+	if strings.Contains(index, "Secret") {
+		t.Errorf("index.html lists the private page")
+	}
+}
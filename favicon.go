@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultFavicon is the icon served when -favicon isn't set
+//
+//go:embed favicon.ico
+var defaultFavicon []byte
+
+// favicon is what /favicon.ico serves, and faviconName the file name its
+// content type is worked out from
+var (
+	favicon     = defaultFavicon
+	faviconName = "favicon.ico"
+)
+
+// faviconModTime is sent as the icon's Last-Modified time, so browsers that
+// revalidate it get a 304 while the server keeps running
+var faviconModTime = time.Now()
+
+// faviconMaxAge is how long browsers may keep the icon without asking again
+const faviconMaxAge = 7 * 24 * time.Hour
+
+// loadFavicon replaces the built-in icon with the contents of a file given
+// to -favicon, such as a .png or .ico
+func loadFavicon(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	favicon, faviconName = data, filepath.Base(path)
+	return nil
+}
+
+// faviconHandler serves the site's icon. Browsers ask for it on every page,
+// so it is cached for a long time rather than answered with a 404 each time
+func faviconHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(faviconMaxAge.Seconds())))
+	http.ServeContent(w, r, faviconName, faviconModTime, bytes.NewReader(favicon))
+}
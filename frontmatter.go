@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// frontMatterFence opens and closes the metadata block at the top of a page
+const frontMatterFence = "---"
+
+// metaLine matches a single "key: value" line of front matter
+var metaLine = regexp.MustCompile(`^(\w[\w-]*):\s*(.*?)\s*$`)
+
+// cssClass matches a single CSS class name
+var cssClass = regexp.MustCompile(`^-?[A-Za-z_][\w-]*$`)
+
+// defaultPageClass is the class every page is shown with, whatever classes
+// its front matter adds
+const defaultPageClass = "page"
+
+// pageMeta is the metadata a page can set in a front matter block at the very
+// top of its source, such as
+//
+//	---
+//	private: true
+//	---
+//
+// Keys the wiki doesn't know about are kept as they were written, so they
+// survive the page being edited and saved again
+type pageMeta struct {
+	// Title is the title the page is shown with, when it differs from the
+	// title it is stored under, such as in the casing it was created with when
+	// titles are case-insensitive
+	Title string
+
+	// Private pages can only be viewed by someone who is logged in
+	Private bool
+
+	// NoIndex asks search engines not to index the page
+	NoIndex bool
+
+	// Locked pages can't be changed until they are unlocked again
+	Locked bool
+
+	// Tags categorize the page, which is listed under each of them on /tags
+	Tags []string
+
+	// Expires is when the page stops being viewable and is deleted by the
+	// janitor, zero for a page that never expires
+	Expires time.Time
+
+	// Class is extra CSS classes, separated by spaces, for the element the
+	// page is shown in, so a stylesheet can give some pages a look of their
+	// own
+	Class string
+
+	extra []string
+}
+
+// parseSource splits a page's source into its front matter and the body that
+// follows. Source that doesn't open with a front matter block, or whose block
+// has a line that isn't "key: value", is all body
+func parseSource(src []byte) (pageMeta, []byte) {
+	var meta pageMeta
+
+	text := string(src)
+	first, rest, ok := strings.Cut(text, "\n")
+	if !ok || strings.TrimRight(first, "\r") != frontMatterFence {
+		return meta, src
+	}
+
+	for {
+		line, after, found := strings.Cut(rest, "\n")
+		line = strings.TrimRight(line, "\r")
+
+		if line == frontMatterFence {
+			return meta, []byte(after)
+		}
+
+		// The block was never closed, or holds something other than
+		// metadata, so the page just starts with a horizontal rule
+		m := metaLine.FindStringSubmatch(line)
+		if !found || (m == nil && strings.TrimSpace(line) != "") {
+			return pageMeta{}, src
+		}
+		if m != nil {
+			meta.set(m[1], m[2], line)
+		}
+		rest = after
+	}
+}
+
+// set records a single front matter line on the metadata
+func (m *pageMeta) set(key, value, line string) {
+	switch strings.ToLower(key) {
+	case "title":
+		m.Title = value
+	case "private":
+		m.Private = isTrue(value)
+	case "noindex":
+		m.NoIndex = isTrue(value)
+	case "locked":
+		m.Locked = isTrue(value)
+	case "tags":
+		m.Tags = parseTags(value)
+	case "class":
+		m.Class = parseClasses(value)
+	case "expires":
+		// A time that can't be read is kept as written rather than lost, but
+		// doesn't make the page expire
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			m.extra = append(m.extra, line)
+			return
+		}
+		m.Expires = t
+	default:
+		m.extra = append(m.extra, line)
+	}
+}
+
+// parseClasses reads the value of a class front matter line, keeping only
+// what is a valid class name
+func parseClasses(value string) string {
+	var classes []string
+	for _, class := range strings.Fields(value) {
+		if cssClass.MatchString(class) {
+			classes = append(classes, class)
+		}
+	}
+	return strings.Join(classes, " ")
+}
+
+// Classes returns the CSS classes the page is shown with: defaultPageClass
+// and any its front matter adds
+func (p Page) Classes() string {
+	if p.Meta.Class == "" {
+		return defaultPageClass
+	}
+	return defaultPageClass + " " + p.Meta.Class
+}
+
+// isTrue reports whether a front matter value switches a flag on
+func isTrue(value string) bool {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true
+	}
+	return false
+}
+
+// lines returns the metadata as front matter lines, without the fences
+func (m pageMeta) lines() []string {
+	var lines []string
+	if m.Title != "" {
+		lines = append(lines, "title: "+m.Title)
+	}
+	if m.Private {
+		lines = append(lines, "private: true")
+	}
+	if m.NoIndex {
+		lines = append(lines, "noindex: true")
+	}
+	if m.Locked {
+		lines = append(lines, "locked: true")
+	}
+	if len(m.Tags) > 0 {
+		lines = append(lines, "tags: ["+strings.Join(m.Tags, ", ")+"]")
+	}
+	if m.Class != "" {
+		lines = append(lines, "class: "+m.Class)
+	}
+	if !m.Expires.IsZero() {
+		lines = append(lines, "expires: "+m.Expires.Format(time.RFC3339))
+	}
+	return append(lines, m.extra...)
+}
+
+// Source returns the page as it is stored and edited: its front matter, if
+// it has any, followed by the body. It is the inverse of parseSource
+func (p Page) Source() []byte {
+	lines := p.Meta.lines()
+	if len(lines) == 0 {
+		return p.Body
+	}
+
+	var b bytes.Buffer
+	b.WriteString(frontMatterFence + "\n")
+	for _, line := range lines {
+		b.WriteString(line + "\n")
+	}
+	b.WriteString(frontMatterFence + "\n")
+	b.Write(p.Body)
+	return b.Bytes()
+}
+
+// pageFromSource builds a page from its stored or submitted source
+func pageFromSource(title string, src []byte) *Page {
+	meta, body := parseSource(src)
+	return &Page{Title: title, Meta: meta, Body: body}
+}
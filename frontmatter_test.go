@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		private bool
+		body    string
+	}{
+		{"no front matter", "just a body\n", false, "just a body\n"},
+		{"private", "---\nprivate: true\n---\nsecret\n", true, "secret\n"},
+		{"private no", "---\nprivate: no\n---\nopen\n", false, "open\n"},
+		{"windows endings", "---\r\nprivate: yes\r\n---\r\nsecret\r\n", true, "secret\r\n"},
+		{"unclosed block", "---\nprivate: true\nbody\n", false, "---\nprivate: true\nbody\n"},
+		{"horizontal rule", "---\nnot metadata\n---\n", false, "---\nnot metadata\n---\n"},
+		{"fence later on", "body\n---\nprivate: true\n---\n", false, "body\n---\nprivate: true\n---\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta, body := parseSource([]byte(tt.src))
+			if meta.Private != tt.private {
+				t.Errorf("Private = %v, want %v", meta.Private, tt.private)
+			}
+			if string(body) != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestSourceRoundTrip(t *testing.T) {
+	// Unknown keys survive being parsed and written back out
+	src := "---\nprivate: true\nauthor: someone\n---\nbody\n"
+	p := pageFromSource("Foo", []byte(src))
+	if got := string(p.Source()); got != src {
+		t.Errorf("Source() = %q, want %q", got, src)
+	}
+
+	// A page without front matter is stored exactly as it was
+	plain := "---\n\nplain body"
+	if got := string(pageFromSource("Foo", []byte(plain)).Source()); got != plain {
+		t.Errorf("Source() = %q, want %q", got, plain)
+	}
+}
+
+func TestParseClasses(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"wide", "wide"},
+		{"  wide   dark ", "wide dark"},
+		{"wide \"><script>", "wide"},
+		{"a:b wide", "wide"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := parseClasses(tt.in); got != tt.want {
+			t.Errorf("parseClasses(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPageClasses(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Styled", "---\nclass: wide dark\n---\nbody\n")
+	savePage(t, "Plain", "body\n")
+
+	for title, want := range map[string]string{"Styled": `<div class="page wide dark"`, "Plain": `<div class="page"`} {
+		body := serve(httptest.NewRequest(http.MethodGet, "/view/"+title, nil)).Body.String()
+		if !strings.Contains(body, want) {
+			t.Errorf("the view of %s has no %s", title, want)
+		}
+	}
+
+	// The classes survive being written back out
+	if p, _ := loadPage("Styled"); !strings.Contains(string(p.Source()), "class: wide dark\n") {
+		t.Errorf("the source lost its class line: %q", p.Source())
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"html/template"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// highlightEnabled turns on syntax highlighting of fenced code blocks that
+// name their language, like ```go
+var highlightEnabled = true
+
+// langSpec describes enough of a language's syntax to pick out its keywords,
+// comments, strings and numbers
+type langSpec struct {
+	keywords     map[string]bool
+	lineComments []string
+	blockComment [2]string
+	quotes       string
+
+	// caseless is set for languages like SQL whose keywords can be written
+	// in any case. Their keywords are listed in lower case
+	caseless bool
+
+	// multiline are the quote characters whose strings can span lines, like
+	// Go's raw strings
+	multiline string
+}
+
+// words turns a space separated list into a set
+func words(list string) map[string]bool {
+	set := map[string]bool{}
+	for _, w := range strings.Fields(list) {
+		set[w] = true
+	}
+	return set
+}
+
+// languages maps the names a fence can give, lowercased, to their syntax
+var languages = map[string]*langSpec{}
+
+func init() {
+	golang := &langSpec{
+		keywords:     words("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var nil true false iota"),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'`",
+		multiline:    "`",
+	}
+	python := &langSpec{
+		keywords:     words("and as assert async await break class continue def del elif else except finally for from global if import in is lambda nonlocal not or pass raise return try while with yield None True False"),
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+	}
+	js := &langSpec{
+		keywords:     words("async await break case catch class const continue debugger default delete do else export extends finally for function if import in instanceof interface let new of return static super switch this throw try type typeof var void while with yield null undefined true false"),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'`",
+		multiline:    "`",
+	}
+	c := &langSpec{
+		keywords:     words("break case char const continue default do double else enum extern float for goto if int long return short signed sizeof static struct switch typedef union unsigned void volatile while bool class delete namespace new nullptr private protected public template this throw try catch using virtual true false NULL"),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'",
+	}
+	java := &langSpec{
+		keywords:     words("abstract boolean break byte case catch char class const continue default do double else enum extends final finally float for if implements import instanceof int interface long new package private protected public return short static super switch this throw throws try void while null true false"),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'",
+	}
+	rust := &langSpec{
+		keywords:     words("as async await break const continue crate else enum extern fn for if impl in let loop match mod move mut pub ref return self Self static struct super trait type unsafe use where while true false"),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"",
+	}
+	shell := &langSpec{
+		keywords:     words("case do done elif else esac export fi for function if in local return then until while"),
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+	}
+	sql := &langSpec{
+		keywords:     words("select from where insert into values update set delete create table drop alter index primary key not null and or join left right inner outer on group by order having limit as distinct union"),
+		caseless:     true,
+		lineComments: []string{"--"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "'\"",
+	}
+	json := &langSpec{
+		keywords: words("true false null"),
+		quotes:   "\"",
+	}
+
+	for spec, names := range map[*langSpec]string{
+		golang: "go golang",
+		python: "python py",
+		js:     "javascript js typescript ts",
+		c:      "c cpp c++ h",
+		java:   "java",
+		rust:   "rust rs",
+		shell:  "sh bash shell zsh",
+		sql:    "sql",
+		json:   "json",
+	} {
+		for _, name := range strings.Fields(names) {
+			languages[name] = spec
+		}
+	}
+}
+
+// highlightCode writes code to b with its keywords, comments, strings and
+// numbers wrapped in spans that static/highlight.css colours. Everything is
+// HTML escaped. It reports false, writing nothing, for a language it doesn't
+// know
+func highlightCode(b *strings.Builder, lang, code string) bool {
+	spec := languages[strings.ToLower(lang)]
+	if spec == nil {
+		return false
+	}
+
+	for i := 0; i < len(code); {
+		if n := spec.comment(code[i:]); n > 0 {
+			writeSpan(b, "hl-comment", code[i:i+n])
+			i += n
+			continue
+		}
+
+		c := code[i]
+		r, size := utf8.DecodeRuneInString(code[i:])
+		switch {
+		case strings.IndexByte(spec.quotes, c) >= 0:
+			n := spec.stringLen(code[i:])
+			writeSpan(b, "hl-string", code[i:i+n])
+			i += n
+		case c >= '0' && c <= '9':
+			n := identLen(code[i:])
+			writeSpan(b, "hl-number", code[i:i+n])
+			i += n
+		case r == '_' || unicode.IsLetter(r):
+			n := identLen(code[i:])
+			if word := code[i : i+n]; spec.isKeyword(word) {
+				writeSpan(b, "hl-keyword", word)
+			} else {
+				b.WriteString(template.HTMLEscapeString(word))
+			}
+			i += n
+		default:
+			b.WriteString(template.HTMLEscapeString(code[i : i+size]))
+			i += size
+		}
+	}
+	return true
+}
+
+// isKeyword reports whether word is one of the language's keywords
+func (spec *langSpec) isKeyword(word string) bool {
+	if spec.caseless {
+		word = strings.ToLower(word)
+	}
+	return spec.keywords[word]
+}
+
+// comment returns the length of the comment at the start of s, or 0 if s
+// doesn't start with one. A block comment that is never closed runs to the
+// end of the code
+func (spec *langSpec) comment(s string) int {
+	for _, start := range spec.lineComments {
+		if strings.HasPrefix(s, start) {
+			if end := strings.IndexByte(s, '\n'); end >= 0 {
+				return end
+			}
+			return len(s)
+		}
+	}
+
+	if opener, closer := spec.blockComment[0], spec.blockComment[1]; opener != "" && strings.HasPrefix(s, opener) {
+		if end := strings.Index(s[len(opener):], closer); end >= 0 {
+			return len(opener) + end + len(closer)
+		}
+		return len(s)
+	}
+	return 0
+}
+
+// stringLen returns the length of the string literal at the start of s,
+// quotes included. Backslash escapes are skipped over, and a string that
+// isn't closed ends with its line, unless its quote allows it to span lines
+func (spec *langSpec) stringLen(s string) int {
+	quote := s[0]
+	multiline := strings.IndexByte(spec.multiline, quote) >= 0
+
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && !multiline:
+			i++
+		case s[i] == quote:
+			return i + 1
+		case s[i] == '\n' && !multiline:
+			return i
+		}
+	}
+	return len(s)
+}
+
+// identLen returns the length of the run of letters, digits and underscores
+// at the start of s. Numbers use it too, which also takes in forms like 0x1F
+// and 1e9, though a decimal point ends them
+func identLen(s string) int {
+	for i, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// writeSpan writes text, escaped, inside a span of the given class
+func writeSpan(b *strings.Builder, class, text string) {
+	b.WriteString(`<span class="` + class + `">` + template.HTMLEscapeString(text) + "</span>")
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRevisions is how many earlier versions of each page are kept. Once a
+// page has more than this the oldest are pruned. 0 turns history off
+var maxRevisions = 20
+
+// Revision identifies an earlier version of a page. ID is the time the
+// version was written in nanoseconds since the Unix epoch, which also names
+// its file in the page's history directory
+type Revision struct {
+	Title string
+	ID    int64
+	Time  time.Time
+}
+
+// historyPage is the data rendered by history.html
+type historyPage struct {
+	Title     string
+	Revisions []Revision
+}
+
+// Wiki returns the name of the wiki the page belongs to
+func (hp historyPage) Wiki() string {
+	wiki, _ := splitWiki(hp.Title)
+	return wiki
+}
+
+// revisionPage is the data rendered by revision.html
+type revisionPage struct {
+	Page
+	Revision Revision
+}
+
+// historyDir returns the directory holding the earlier versions of the page
+// with the given title
+func historyDir(title string) string {
+	wiki, name := splitWiki(title)
+	return filepath.Join(wikiDir(wiki), "history", name)
+}
+
+// revisionFile returns the path of a single earlier version of a page
+func revisionFile(title string, id int64) string {
+	return filepath.Join(historyDir(title), strconv.FormatInt(id, 10)+fileExt)
+}
+
+// saveRevision copies the current version of a page into its history
+// directory before it gets overwritten, then prunes the history down to
+// maxRevisions. A page that doesn't exist yet has nothing to keep. The caller
+// must hold the page lock
+func saveRevision(title string) error {
+	if maxRevisions <= 0 {
+		return nil
+	}
+
+	current, err := os.Open(pageFile(title))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer current.Close()
+
+	info, err := current.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(historyDir(title), 0700); err != nil {
+		return err
+	}
+
+	// The revision is named after when that version was written
+	rev, err := os.OpenFile(revisionFile(title, info.ModTime().UnixNano()), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(rev, current); err != nil {
+		rev.Close()
+		return err
+	}
+	if err := rev.Close(); err != nil {
+		return err
+	}
+
+	return pruneRevisions(title)
+}
+
+// pruneRevisions removes the oldest versions of a page until no more than
+// maxRevisions are left
+func pruneRevisions(title string) error {
+	revs, err := listRevisions(title)
+	if err != nil {
+		return err
+	}
+
+	// listRevisions returns the newest first, so everything past the cap is
+	// the oldest
+	for len(revs) > maxRevisions {
+		oldest := revs[len(revs)-1]
+		if err := os.Remove(revisionFile(title, oldest.ID)); err != nil {
+			return err
+		}
+		revs = revs[:len(revs)-1]
+	}
+	return nil
+}
+
+// renameHistory moves the earlier versions of a page to the history of its
+// new title. The new title may already have history of its own left over
+// from a deleted page, so revisions are moved one at a time rather than
+// renaming the whole directory. The caller must hold both page locks
+func renameHistory(oldTitle, newTitle string) error {
+	revs, err := listRevisions(oldTitle)
+	if err != nil || len(revs) == 0 {
+		return err
+	}
+
+	if err := os.MkdirAll(historyDir(newTitle), 0700); err != nil {
+		return err
+	}
+
+	for _, rev := range revs {
+		if err := os.Rename(revisionFile(oldTitle, rev.ID), revisionFile(newTitle, rev.ID)); err != nil {
+			return err
+		}
+	}
+
+	// Anything that isn't a revision is left behind, so this only removes
+	// the directory if it is now empty
+	os.Remove(historyDir(oldTitle))
+	return pruneRevisions(newTitle)
+}
+
+// listRevisions returns the earlier versions of a page, newest first. A page
+// without any history has no revisions rather than an error
+func listRevisions(title string) ([]Revision, error) {
+	if err := checkFileTitle(title); err != nil {
+		return nil, err
+	}
+
+	files, err := os.ReadDir(historyDir(title))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var revs []Revision
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), fileExt) {
+			continue
+		}
+
+		// Skips anything that isn't named after a timestamp
+		id, err := strconv.ParseInt(strings.TrimSuffix(f.Name(), fileExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		revs = append(revs, Revision{Title: title, ID: id, Time: time.Unix(0, id)})
+	}
+
+	sort.Slice(revs, func(i, j int) bool { return revs[i].ID > revs[j].ID })
+	return revs, nil
+}
+
+// loadRevision reads a single earlier version of a page
+func loadRevision(title string, id int64) (*Page, error) {
+	if err := checkFileTitle(title); err != nil {
+		return nil, err
+	}
+
+	unlock := lockPage(title)
+	defer unlock()
+
+	data, err := os.ReadFile(revisionFile(title, id))
+	if err != nil {
+		return nil, err
+	}
+
+	// Revisions are copies of the page file, so encrypted the same way
+	src, err := openPage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	p := pageFromSource(title, src)
+	p.ModTime = time.Unix(0, id)
+	return p, nil
+}
+
+// historyHandler lists the earlier versions of a page with links to each of
+// them. With a ?rev=<id> query it shows that version instead, along with a
+// button to restore it
+func historyHandler(w http.ResponseWriter, r *http.Request, title string) {
+	// Earlier versions of a private page are just as private
+	if !checkPageAccess(w, r, title) {
+		return
+	}
+
+	if rev := r.URL.Query().Get("rev"); rev != "" {
+		revisionHandler(w, r, title, rev)
+		return
+	}
+
+	revs, err := listRevisions(title)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	renderTemplate(w, "history", historyPage{Title: title, Revisions: revs})
+}
+
+// revisionHandler renders the version of a page identified by rev, or 404 if
+// there is no such version
+func revisionHandler(w http.ResponseWriter, r *http.Request, title, rev string) {
+	id, err := strconv.ParseInt(rev, 10, 64)
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+
+	p, err := loadRevision(title, id)
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+
+	// The version itself may have expired or been private even if the page
+	// isn't now
+	if !checkNotExpired(w, p) {
+		return
+	}
+	if !canView(r, p) {
+		requestAuth(w)
+		return
+	}
+
+	p.HTML = renderMarkdown(p.Wiki(), p.Body)
+	p.CSRFToken = csrfToken(w, r)
+	renderTemplate(w, "revision", revisionPage{
+		Page:     *p,
+		Revision: Revision{Title: title, ID: id, Time: p.ModTime},
+	})
+}
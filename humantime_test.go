@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHumanTimeAt(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	tests := []struct {
+		ago  time.Duration
+		want string
+	}{
+		{0, "just now"},
+		{59 * time.Second, "just now"},
+		{-time.Hour, "just now"},
+		{time.Minute, "1 minute ago"},
+		{3 * time.Minute, "3 minutes ago"},
+		{59*time.Minute + 59*time.Second, "59 minutes ago"},
+		{time.Hour, "1 hour ago"},
+		{23 * time.Hour, "23 hours ago"},
+		{day, "yesterday"},
+		{47 * time.Hour, "yesterday"},
+		{2 * day, "2 days ago"},
+		{29 * day, "29 days ago"},
+		{30 * day, "1 month ago"},
+		{364 * day, "12 months ago"},
+		{365 * day, "1 year ago"},
+		{3 * 365 * day, "3 years ago"},
+	}
+
+	for _, tt := range tests {
+		if got := humanTimeAt(now.Add(-tt.ago), now); got != tt.want {
+			t.Errorf("humanTimeAt(now - %v) = %q, want %q", tt.ago, got, tt.want)
+		}
+	}
+
+	// A page with no time at all shows nothing rather than "55 years ago"
+	if got := humanTimeAt(time.Time{}, now); got != "" {
+		t.Errorf("humanTimeAt(zero) = %q, want \"\"", got)
+	}
+}
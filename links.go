@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// linkingPage is a page that links to another, as listed under the page it
+// links to
+type linkingPage struct {
+	Title string
+	Meta  pageMeta
+}
+
+// linkIndex maps each wiki's pages to the pages that link to them. A wiki's
+// links are found by scanning its pages the first time they are needed, and
+// from then on only the links of a page that changes are looked at again
+type linkIndex struct {
+	mu     sync.Mutex
+	byWiki map[string]*wikiLinks
+}
+
+// wikiLinks are the links between the pages of one wiki, both ways round. To
+// lists the pages linking to each page in title order, and from the pages
+// each page links to, so its links can be taken out again when it changes
+type wikiLinks struct {
+	to   map[string][]linkingPage
+	from map[string][]string
+}
+
+// backlinks is the index behind /orphans and the links listed on each page
+var backlinks = &linkIndex{byWiki: map[string]*wikiLinks{}}
+
+// Keeps the links of every changed page up to date
+func init() {
+	changeHooks = append(changeHooks, backlinks.changed)
+}
+
+// changed replaces the links of the page with the given title with those it
+// has now, or none if it is gone. A wiki that hasn't been scanned yet is left
+// alone, since its scan will find them
+func (li *linkIndex) changed(title string) {
+	wiki, _ := splitWiki(title)
+	li.mu.Lock()
+	defer li.mu.Unlock()
+
+	links, ok := li.byWiki[wiki]
+	if !ok {
+		return
+	}
+	links.remove(title)
+	if p, err := store.Load(context.Background(), title); err == nil {
+		links.add(p)
+	}
+}
+
+// index returns the links of the request's wiki, scanning them if needed. It
+// must be called with li.mu held
+func (li *linkIndex) index(ctx context.Context) (*wikiLinks, error) {
+	wiki := wikiFromContext(ctx)
+	if links, ok := li.byWiki[wiki]; ok {
+		return links, nil
+	}
+	links, err := scanLinks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	li.byWiki[wiki] = links
+	return links, nil
+}
+
+// linksTo returns the titles of the pages linking to the page with the given
+// title that the request may see, in title order
+func (li *linkIndex) linksTo(r *http.Request, title string) ([]string, error) {
+	li.mu.Lock()
+	defer li.mu.Unlock()
+	links, err := li.index(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	var titles []string
+	for _, from := range links.to[title] {
+		if canViewMeta(r, from.Meta) {
+			titles = append(titles, from.Title)
+		}
+	}
+	return titles, nil
+}
+
+// linked returns the titles of the pages of the context's wiki that at least
+// one other page links to
+func (li *linkIndex) linked(ctx context.Context) (map[string]bool, error) {
+	li.mu.Lock()
+	defer li.mu.Unlock()
+	links, err := li.index(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	linked := make(map[string]bool, len(links.to))
+	for title, from := range links.to {
+		if len(from) > 0 {
+			linked[title] = true
+		}
+	}
+	return linked, nil
+}
+
+// scanLinks reads every page of the context's wiki and returns the links
+// between them. A page that can't be loaded links nowhere
+func scanLinks(ctx context.Context) (*wikiLinks, error) {
+	titles, err := store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	links := &wikiLinks{to: map[string][]linkingPage{}, from: map[string][]string{}}
+	for _, title := range titles {
+		p, err := store.Load(ctx, title)
+		if err != nil {
+			// Cancelled part way through, what was found so far would be
+			// wrong, so none of it is kept
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			continue
+		}
+		links.add(p)
+	}
+	return links, nil
+}
+
+// add records the links of p. Links from a page to itself aren't included
+func (links *wikiLinks) add(p *Page) {
+	var targets []string
+	for _, name := range pageLinks(p.Wiki(), p.Body) {
+		target := canonicalTitle(wikiTitle(p.Wiki(), name))
+		if target == p.Title {
+			continue
+		}
+		targets = append(targets, target)
+
+		// Keeps the pages linking to the target in title order
+		from := links.to[target]
+		i := sort.Search(len(from), func(i int) bool { return from[i].Title >= p.Title })
+		from = append(from, linkingPage{})
+		copy(from[i+1:], from[i:])
+		from[i] = linkingPage{Title: p.Title, Meta: p.Meta}
+		links.to[target] = from
+	}
+	if len(targets) > 0 {
+		links.from[p.Title] = targets
+	}
+}
+
+// remove takes out the links of the page with the given title
+func (links *wikiLinks) remove(title string) {
+	for _, target := range links.from[title] {
+		var kept []linkingPage
+		for _, from := range links.to[target] {
+			if from.Title != title {
+				kept = append(kept, from)
+			}
+		}
+		if len(kept) > 0 {
+			links.to[target] = kept
+		} else {
+			delete(links.to, target)
+		}
+	}
+	delete(links.from, title)
+}
+
+// orphansPage is the data rendered by orphans.html
+type orphansPage struct {
+	Wiki   string
+	Titles []string
+}
+
+// orphansHandler lists the pages no other page links to, leaving out the
+// home page, which is reached from / instead. Links from private pages count
+// like any other, but private orphans are only listed for those who can view
+// them
+func orphansHandler(w http.ResponseWriter, r *http.Request) {
+	linked, err := backlinks.linked(r.Context())
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	// Titles are listed in the store's order, so the orphans are sorted too
+	titles, err := store.List(r.Context())
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	wiki := wikiFromContext(r.Context())
+	data := orphansPage{Wiki: wiki}
+	for _, title := range titles {
+		if linked[title] || (homePage != "" && title == canonicalTitle(wikiTitle(wiki, homePage))) {
+			continue
+		}
+		if p, err := store.Load(r.Context(), title); err == nil && canView(r, p) {
+			data.Titles = append(data.Titles, title)
+		}
+	}
+
+	renderTemplate(w, "orphans", data)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// listedPages returns the titles of the pages the listing r asks for links to, in
+// the order they are listed
+func listedPages(t *testing.T, r *http.Request) []string {
+	t.Helper()
+
+	var titles []string
+	for _, m := range regexp.MustCompile(`<li><a href="/view/(\w+)">`).FindAllStringSubmatch(serve(r).Body.String(), -1) {
+		titles = append(titles, m[1])
+	}
+	return titles
+}
+
+func TestOrphans(t *testing.T) {
+	useTempWiki(t)
+	old := homePage
+	homePage = "Home"
+	defer func() { homePage = old }()
+
+	savePage(t, "Home", "Start at [A].\n")
+	savePage(t, "A", "On to [B].\n")
+	savePage(t, "B", "Back to [A].\n")
+	savePage(t, "Self", "Only [Self] links here.\n")
+	savePage(t, "Alone", "No links at all.\n")
+	savePage(t, "Dangling", "Links to [Missing].\n")
+	savePage(t, "Secret", "---\nprivate: true\n---\nSee [FromSecret].\n")
+	savePage(t, "FromSecret", "Only the private page links here.\n")
+
+	// The home page is reached from / and a link to itself doesn't count.
+	// Links from a private page still do
+	useAuth(t, "admin", "secret")
+	want := []string{"Alone", "Dangling", "Self"}
+	if got := listedPages(t, httptest.NewRequest(http.MethodGet, "/orphans", nil)); !reflect.DeepEqual(got, want) {
+		t.Errorf("orphans = %v, want %v", got, want)
+	}
+
+	// The private orphan is only listed for those who can view it
+	r := withBasicAuth(httptest.NewRequest(http.MethodGet, "/orphans", nil), "admin", "secret")
+	want = []string{"Alone", "Dangling", "Secret", "Self"}
+	if got := listedPages(t, r); !reflect.DeepEqual(got, want) {
+		t.Errorf("orphans when signed in = %v, want %v", got, want)
+	}
+
+	// Linking to an orphan adopts it
+	savePage(t, "Alone", "Now see [Self].\n")
+	want = []string{"Alone", "Dangling"}
+	if got := listedPages(t, httptest.NewRequest(http.MethodGet, "/orphans", nil)); !reflect.DeepEqual(got, want) {
+		t.Errorf("orphans after linking Self = %v, want %v", got, want)
+	}
+}
+
+func TestBacklinksFollowEdits(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Target", "target\n")
+	view := func() []string {
+		return listedPages(t, httptest.NewRequest(http.MethodGet, "/view/Target", nil))
+	}
+
+	if got := view(); got != nil {
+		t.Fatalf("backlinks before any link = %v", got)
+	}
+
+	serve(postForm("/save/Linker", url.Values{"body": {"See [Target].\n"}}))
+	if got := view(); !reflect.DeepEqual(got, []string{"Linker"}) {
+		t.Errorf("backlinks after linking = %v, want [Linker]", got)
+	}
+
+	serve(postForm("/save/Linker", url.Values{"body": {"No link any more.\n"}}))
+	if got := view(); got != nil {
+		t.Errorf("backlinks after the link was removed = %v, want none", got)
+	}
+
+	// Deleting a page takes its links with it
+	serve(postForm("/save/Other", url.Values{"body": {"Also [Target].\n"}}))
+	serve(postForm("/delete/Other", nil))
+	if got := view(); got != nil {
+		t.Errorf("backlinks after the linking page was deleted = %v, want none", got)
+	}
+}
+
+func TestBacklinksNotStaleWhenRevalidated(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Target", "target\n")
+
+	first := serve(httptest.NewRequest(http.MethodGet, "/view/Target", nil))
+	etag := first.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("the view has no ETag")
+	}
+
+	// Nothing changed, so the cached copy is still good
+	r := httptest.NewRequest(http.MethodGet, "/view/Target", nil)
+	r.Header.Set("If-None-Match", etag)
+	if w := serve(r); w.Code != http.StatusNotModified {
+		t.Fatalf("revalidating unchanged: status = %d, want %d", w.Code, http.StatusNotModified)
+	}
+
+	// A new link to the page changes how it is shown, though not the page
+	// itself
+	serve(postForm("/save/Linker", url.Values{"body": {"See [Target].\n"}}))
+	r = httptest.NewRequest(http.MethodGet, "/view/Target", nil)
+	r.Header.Set("If-None-Match", etag)
+	w := serve(r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("revalidating after a new backlink: status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if !strings.Contains(w.Body.String(), `href="/view/Linker"`) {
+		t.Errorf("the fresh view doesn't list the new backlink")
+	}
+	if w.Header().Get("ETag") == etag {
+		t.Errorf("the ETag didn't change with the backlinks")
+	}
+}
+
+func TestBacklinksUpdatedInPlace(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Target", "target\n")
+	savePage(t, "First", "See [Target].\n")
+	view := func() []string {
+		return listedPages(t, httptest.NewRequest(http.MethodGet, "/view/Target", nil))
+	}
+	view()
+	built := backlinks.byWiki[""]
+	if built == nil {
+		t.Fatal("viewing the page didn't build the index")
+	}
+
+	// A save only changes the links of the page saved, without the index
+	// being thrown away and scanned again
+	savePage(t, "Second", "Also [Target] and [Elsewhere].\n")
+	savePage(t, "First", "On to [Elsewhere] instead.\n")
+	if backlinks.byWiki[""] != built {
+		t.Fatal("a save threw the index away")
+	}
+	if got := view(); !reflect.DeepEqual(got, []string{"Second"}) {
+		t.Errorf("backlinks of Target = %v, want [Second]", got)
+	}
+	if got := built.to["Elsewhere"]; len(got) != 2 || got[0].Title != "First" || got[1].Title != "Second" {
+		t.Errorf("backlinks of Elsewhere = %v, want First and Second in order", got)
+	}
+
+	// The links of a page made private are only listed for those who can see it
+	useAuth(t, "admin", "secret")
+	savePage(t, "Second", "---\nprivate: true\n---\nAlso [Target].\n")
+	if got := view(); got != nil {
+		t.Errorf("backlinks of Target from a private page = %v, want none", got)
+	}
+	r := withBasicAuth(httptest.NewRequest(http.MethodGet, "/view/Target", nil), "admin", "secret")
+	if got := listedPages(t, r); !reflect.DeepEqual(got, []string{"Second"}) {
+		t.Errorf("backlinks of Target when signed in = %v, want [Second]", got)
+	}
+
+	// A rename moves the page's links with it
+	if err := store.Rename(context.Background(), "Second", "Renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if got := listedPages(t, r); !reflect.DeepEqual(got, []string{"Renamed"}) {
+		t.Errorf("backlinks of Target after the rename = %v, want [Renamed]", got)
+	}
+	if _, ok := built.from["Second"]; ok {
+		t.Errorf("the renamed page's old title still has links")
+	}
+}
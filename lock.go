@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+)
+
+// errLocked is returned for a change to a page that has been locked, whether
+// a save, a delete or a rename
+var errLocked = errors.New("this page is locked, unlock it before changing it")
+
+// checkUnlocked returns errLocked if the page with the given title exists and
+// is locked. A page that doesn't exist yet can always be created
+func checkUnlocked(ctx context.Context, title string) error {
+	p, err := store.Load(ctx, title)
+	if err == nil && p.Meta.Locked {
+		return errLocked
+	}
+	return nil
+}
+
+// lockHandler locks the page with the given title when the posted locked
+// value is "true" and unlocks it otherwise. The lock is kept in the page's
+// front matter, so it goes wherever the page does
+func lockHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if !checkForm(w, r) {
+		return
+	}
+
+	p, err := store.Load(r.Context(), title)
+	if errors.Is(err, os.ErrNotExist) {
+		notFound(w, r)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	// The loaded page may be the one in the cache, so it's changed on a copy
+	changed := *p
+	changed.Meta.Locked = r.PostFormValue("locked") == "true"
+	if err := store.Save(r.Context(), &changed); err != nil {
+		serverError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, pageURL("view", title), http.StatusFound)
+}
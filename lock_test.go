@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLockedPageRefusesChanges(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Fixed", "---\nlocked: true\n---\nsettled\n")
+
+	for _, r := range []*http.Request{
+		postForm("/save/Fixed", url.Values{"body": {"changed"}}),
+		postForm("/delete/Fixed", nil),
+		postForm("/rename/Fixed", url.Values{"newtitle": {"Moved"}}),
+		httptest.NewRequest(http.MethodPut, "/api/pages/Fixed", strings.NewReader(`{"body":"changed"}`)),
+		httptest.NewRequest(http.MethodDelete, "/api/pages/Fixed", nil),
+	} {
+		if w := serve(r); w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: status = %d, want %d", r.Method, r.URL.Path, w.Code, http.StatusForbidden)
+		}
+	}
+
+	// The page is where it was and as it was
+	cache.clear()
+	p, err := loadPage("Fixed")
+	if err != nil || string(p.Body) != "settled\n" || !p.Meta.Locked {
+		t.Errorf("after the refused changes: %v, %v", p, err)
+	}
+	if _, err := os.Stat(pageFile("Moved")); !os.IsNotExist(err) {
+		t.Errorf("the locked page was renamed")
+	}
+
+	// The edit form shows it read-only, offering to unlock it instead
+	body := serve(httptest.NewRequest(http.MethodGet, "/edit/Fixed", nil)).Body.String()
+	if !strings.Contains(body, "readonly") || strings.Contains(body, `value="Save"`) {
+		t.Errorf("the edit form of a locked page can still be saved")
+	}
+	if !strings.Contains(body, `action="/lock/Fixed"`) {
+		t.Errorf("the edit form of a locked page doesn't offer to unlock it")
+	}
+}
+
+func TestUnlockAllowsSaving(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Fixed", "---\nlocked: true\n---\nsettled\n")
+
+	if w := serve(postForm("/lock/Fixed", url.Values{"locked": {"false"}})); w.Code != http.StatusFound {
+		t.Fatalf("unlocking: status = %d, want %d", w.Code, http.StatusFound)
+	}
+	if w := serve(postForm("/save/Fixed", url.Values{"body": {"changed"}})); w.Code != http.StatusFound {
+		t.Fatalf("saving once unlocked: status = %d, want %d", w.Code, http.StatusFound)
+	}
+	p, err := loadPage("Fixed")
+	if err != nil || string(p.Body) != "changed\n" {
+		t.Errorf("after unlocking and saving: %v, %v", p, err)
+	}
+
+	// Locking again takes effect straight away
+	if w := serve(postForm("/lock/Fixed", url.Values{"locked": {"true"}})); w.Code != http.StatusFound {
+		t.Fatalf("locking: status = %d, want %d", w.Code, http.StatusFound)
+	}
+	if w := serve(postForm("/save/Fixed", url.Values{"body": {"again"}})); w.Code != http.StatusForbidden {
+		t.Errorf("saving once locked again: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestLockNeedsCSRFToken(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Fixed", "---\nlocked: true\n---\nsettled\n")
+
+	r := httptest.NewRequest(http.MethodPost, "/lock/Fixed", strings.NewReader("locked=false"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if w := serve(r); w.Code != http.StatusForbidden {
+		t.Errorf("unlocking without a token: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if p, err := loadPage("Fixed"); err != nil || !p.Meta.Locked {
+		t.Errorf("the page was unlocked without a token")
+	}
+}
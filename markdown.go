@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// wikiLink matches an internal link to another page, such as [SomePage], at
+// the start of a string
+var wikiLink = regexp.MustCompile(`^\[(\w+)\]`)
+
+// mdWriter collects the HTML being rendered, along with the headings written
+// so far so they can be given unique ids and listed in a table of contents,
+// and the titles of the pages linked to. wiki is the wiki the page belongs
+// to, which [SomePage] links point into
+type mdWriter struct {
+	strings.Builder
+	wiki     string
+	headings []tocHeading
+	slugs    map[string]bool
+	links    []string
+}
+
+// renderMarkdown converts a page body written in Markdown into HTML. Raw HTML
+// in the source is always escaped rather than passed through, so the result is
+// safe to hand to a template without further sanitizing. Links to other pages
+// go to pages of the given wiki
+func renderMarkdown(wiki string, src []byte) template.HTML {
+	html, _ := renderMarkdownTOC(wiki, src)
+	return html
+}
+
+// renderMarkdownTOC converts a page body like renderMarkdown does, and also
+// returns a table of contents linking to its headings. The table is empty
+// when the page has fewer than tocMinHeadings headings
+func renderMarkdownTOC(wiki string, src []byte) (html, toc template.HTML) {
+	// Treats Windows line endings the same as Unix ones
+	text := strings.ReplaceAll(string(src), "\r\n", "\n")
+
+	b := &mdWriter{wiki: wiki, slugs: map[string]bool{}}
+	renderBlocks(b, strings.Split(text, "\n"))
+
+	if tocMinHeadings > 0 && len(b.headings) >= tocMinHeadings {
+		toc = renderTOC(b.headings)
+	}
+	return template.HTML(b.String()), toc
+}
+
+// pageLinks returns the titles of the pages a page body links to with
+// [SomePage], each once, in the order they first appear. The body is rendered
+// to find them, so anything that doesn't render as a link, like the text of a
+// code block, isn't one
+func pageLinks(wiki string, src []byte) []string {
+	text := strings.ReplaceAll(string(src), "\r\n", "\n")
+	b := &mdWriter{wiki: wiki, slugs: map[string]bool{}}
+	renderBlocks(b, strings.Split(text, "\n"))
+
+	seen := map[string]bool{}
+	var links []string
+	for _, title := range b.links {
+		if !seen[title] {
+			seen[title] = true
+			links = append(links, title)
+		}
+	}
+	return links
+}
+
+// renderBlocks writes the block level elements (headings, paragraphs, lists,
+// quotes, code blocks and rules) found in lines to b
+func renderBlocks(b *mdWriter, lines []string) {
+	for i := 0; i < len(lines); {
+		trimmed := strings.TrimSpace(lines[i])
+
+		switch {
+		case trimmed == "":
+			// Blank lines only separate blocks
+			i++
+		case isFence(trimmed):
+			i = renderCodeBlock(b, lines, i)
+		case headingLevel(trimmed) > 0:
+			renderHeading(b, trimmed)
+			i++
+		case isRule(trimmed):
+			b.WriteString("<hr>\n")
+			i++
+		case strings.HasPrefix(trimmed, ">"):
+			i = renderQuote(b, lines, i)
+		case listMarker(trimmed) != "":
+			i = renderList(b, lines, i)
+		default:
+			i = renderParagraph(b, lines, i)
+		}
+	}
+}
+
+// startsBlock reports whether a trimmed line begins a block other than a
+// paragraph, which ends any paragraph being collected
+func startsBlock(trimmed string) bool {
+	return isFence(trimmed) || headingLevel(trimmed) > 0 || isRule(trimmed) ||
+		strings.HasPrefix(trimmed, ">") || listMarker(trimmed) != ""
+}
+
+// isFence reports whether a trimmed line opens or closes a fenced code block
+func isFence(trimmed string) bool {
+	return strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~")
+}
+
+// headingLevel returns the level (1-6) of an ATX heading such as "## Title",
+// or 0 if the line isn't a heading
+func headingLevel(trimmed string) int {
+	level := 0
+	for level < len(trimmed) && trimmed[level] == '#' {
+		level++
+	}
+
+	// The hashes have to be followed by a space, otherwise "#tag" would be
+	// read as a heading
+	if level == 0 || level > 6 || level == len(trimmed) || trimmed[level] != ' ' {
+		return 0
+	}
+	return level
+}
+
+// isRule reports whether a trimmed line is a horizontal rule made of three or
+// more '-', '*' or '_' characters
+func isRule(trimmed string) bool {
+	compact := strings.ReplaceAll(trimmed, " ", "")
+	if len(compact) < 3 {
+		return false
+	}
+	return strings.Count(compact, compact[:1]) == len(compact) && strings.ContainsAny(compact[:1], "-*_")
+}
+
+// listMarker returns the marker that starts a list item ("-", "*", "+" or a
+// number followed by "."), or "" if the trimmed line isn't a list item
+func listMarker(trimmed string) string {
+	if len(trimmed) > 1 && strings.ContainsAny(trimmed[:1], "-*+") && trimmed[1] == ' ' {
+		return trimmed[:1]
+	}
+
+	digits := 0
+	for digits < len(trimmed) && trimmed[digits] >= '0' && trimmed[digits] <= '9' {
+		digits++
+	}
+	if digits > 0 && digits+1 < len(trimmed) && trimmed[digits] == '.' && trimmed[digits+1] == ' ' {
+		return trimmed[:digits+1]
+	}
+	return ""
+}
+
+// renderHeading writes an <h1>-<h6> element for a trimmed heading line. Each
+// heading gets an id made from its text so it can be linked to
+func renderHeading(b *mdWriter, trimmed string) {
+	level := headingLevel(trimmed)
+	text := strings.TrimSpace(strings.TrimRight(trimmed[level:], "#"))
+	tag := "h" + string(rune('0'+level))
+
+	// The heading's content is rendered on its own first, since the id
+	// comes from the text it ends up showing
+	inner := &mdWriter{wiki: b.wiki, slugs: b.slugs}
+	renderInline(inner, text)
+	b.links = append(b.links, inner.links...)
+	label := plainText(inner.String())
+	slug := b.uniqueSlug(slugify(label))
+	b.headings = append(b.headings, tocHeading{Level: level, Slug: slug, Text: label})
+
+	b.WriteString("<" + tag + ` id="` + slug + `">`)
+	b.WriteString(inner.String())
+	b.WriteString("</" + tag + ">\n")
+}
+
+// renderCodeBlock writes the fenced code block that opens at lines[start] and
+// returns the index of the first line after it. An unclosed fence runs to the
+// end of the page
+func renderCodeBlock(b *mdWriter, lines []string, start int) int {
+	open := strings.TrimSpace(lines[start])
+	fence := open[:3]
+	lang := strings.TrimSpace(open[3:])
+
+	i := start + 1
+	var code []string
+	for ; i < len(lines); i++ {
+		if strings.HasPrefix(strings.TrimSpace(lines[i]), fence) {
+			i++
+			break
+		}
+		code = append(code, lines[i])
+	}
+
+	if lang != "" {
+		b.WriteString(`<pre><code class="language-` + template.HTMLEscapeString(lang) + `">`)
+	} else {
+		b.WriteString("<pre><code>")
+	}
+
+	// Languages the highlighter doesn't know are shown as plain text
+	text := strings.Join(code, "\n")
+	if !highlightEnabled || lang == "" || !highlightCode(&b.Builder, lang, text) {
+		b.WriteString(template.HTMLEscapeString(text))
+	}
+	b.WriteString("</code></pre>\n")
+	return i
+}
+
+// renderQuote writes the block quote that starts at lines[start] and returns
+// the index of the first line after it. The quoted text is rendered as
+// Markdown in its own right
+func renderQuote(b *mdWriter, lines []string, start int) int {
+	i := start
+	var inner []string
+	for ; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(trimmed, ">") {
+			break
+		}
+		inner = append(inner, strings.TrimPrefix(trimmed[1:], " "))
+	}
+
+	b.WriteString("<blockquote>\n")
+	renderBlocks(b, inner)
+	b.WriteString("</blockquote>\n")
+	return i
+}
+
+// renderList writes the ordered or unordered list that starts at lines[start]
+// and returns the index of the first line after it. Lines that don't start a
+// new item are treated as a continuation of the previous one
+func renderList(b *mdWriter, lines []string, start int) int {
+	ordered := !strings.ContainsAny(listMarker(strings.TrimSpace(lines[start]))[:1], "-*+")
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+	}
+
+	i := start
+	var items []string
+	for ; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "" {
+			break
+		}
+
+		marker := listMarker(trimmed)
+		if marker == "" {
+			// Another kind of block ends the list, plain text continues the
+			// current item
+			if startsBlock(trimmed) {
+				break
+			}
+			items[len(items)-1] += " " + trimmed
+			continue
+		}
+
+		// Switching between numbered and bulleted items ends the list
+		if ordered == strings.ContainsAny(marker[:1], "-*+") {
+			break
+		}
+		items = append(items, strings.TrimSpace(trimmed[len(marker):]))
+	}
+
+	b.WriteString("<" + tag + ">\n")
+	for _, item := range items {
+		b.WriteString("<li>")
+		renderInline(b, item)
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</" + tag + ">\n")
+	return i
+}
+
+// renderParagraph writes the paragraph that starts at lines[start] and returns
+// the index of the first line after it
+func renderParagraph(b *mdWriter, lines []string, start int) int {
+	i := start
+	var text []string
+	for ; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "" || (i > start && startsBlock(trimmed)) {
+			break
+		}
+		text = append(text, trimmed)
+	}
+
+	b.WriteString("<p>")
+	renderInline(b, strings.Join(text, "\n"))
+	b.WriteString("</p>\n")
+	return i
+}
+
+// renderInline writes text to b with its inline Markdown (code spans, links,
+// strong and emphasis) converted to HTML and everything else escaped
+func renderInline(b *mdWriter, text string) {
+	for i := 0; i < len(text); {
+		c := text[i]
+
+		switch {
+		case c == '\\' && i+1 < len(text) && strings.IndexByte("\\`*[]()#+-.!_>", text[i+1]) >= 0:
+			// A backslash makes the next punctuation character literal
+			b.WriteString(template.HTMLEscapeString(text[i+1 : i+2]))
+			i += 2
+			continue
+		case c == '`':
+			if end := strings.IndexByte(text[i+1:], '`'); end >= 0 {
+				b.WriteString("<code>" + template.HTMLEscapeString(text[i+1:i+1+end]) + "</code>")
+				i += end + 2
+				continue
+			}
+		case c == '*' && strings.HasPrefix(text[i:], "**"):
+			if end := strings.Index(text[i+2:], "**"); end > 0 {
+				b.WriteString("<strong>")
+				renderInline(b, text[i+2:i+2+end])
+				b.WriteString("</strong>")
+				i += end + 4
+				continue
+			}
+		case c == '*':
+			if end := strings.IndexByte(text[i+1:], '*'); end > 0 {
+				b.WriteString("<em>")
+				renderInline(b, text[i+1:i+1+end])
+				b.WriteString("</em>")
+				i += end + 2
+				continue
+			}
+		case c == '[':
+			if n := renderLink(b, text[i:]); n > 0 {
+				i += n
+				continue
+			}
+			if n := renderWikiLink(b, text[i:]); n > 0 {
+				i += n
+				continue
+			}
+		}
+
+		// Anything that isn't markup is written out escaped
+		b.WriteString(template.HTMLEscapeString(text[i : i+1]))
+		i++
+	}
+}
+
+// renderLink writes a [text](url) link found at the start of text and returns
+// how many bytes it consumed, or 0 if text doesn't start with a link
+func renderLink(b *mdWriter, text string) int {
+	closeText := strings.IndexByte(text, ']')
+	if closeText < 0 || !strings.HasPrefix(text[closeText+1:], "(") {
+		return 0
+	}
+	closeURL := strings.IndexByte(text[closeText+2:], ')')
+	if closeURL < 0 {
+		return 0
+	}
+
+	label := text[1:closeText]
+	href := strings.TrimSpace(text[closeText+2 : closeText+2+closeURL])
+
+	b.WriteString(`<a href="` + template.HTMLEscapeString(safeURL(href)) + `">`)
+	renderInline(b, label)
+	b.WriteString("</a>")
+	return closeText + 3 + closeURL
+}
+
+// renderWikiLink writes a link for a [SomePage] reference found at the start
+// of text and returns how many bytes it consumed, or 0 if text doesn't start
+// with one. Links to pages that don't exist yet get the "missing" class so
+// they stand out. Rendering doesn't know which request it is for, so the
+// lookup isn't tied to one
+func renderWikiLink(b *mdWriter, text string) int {
+	m := wikiLink.FindStringSubmatch(text)
+	if m == nil {
+		return 0
+	}
+
+	// The link is looked up and goes to the title the page is stored with,
+	// but shows the case it was written in. Both are escaped like anything
+	// else written into the HTML, even though titles are only word characters
+	title := m[1]
+	b.links = append(b.links, title)
+	target := canonicalTitle(wikiTitle(b.wiki, title))
+	href := template.HTMLEscapeString(pageURL("view", target))
+	name := template.HTMLEscapeString(title)
+	if pageExists(context.Background(), target) {
+		b.WriteString(`<a href="` + href + `">` + name + "</a>")
+	} else {
+		b.WriteString(`<a class="missing" href="` + href + `">` + name + "</a>")
+	}
+	return len(m[0])
+}
+
+// safeURL returns href if it is a relative URL or uses a scheme that can't run
+// script, such as http or mailto. Anything else, like javascript:, is
+// replaced with a harmless "#"
+func safeURL(href string) string {
+	u, err := url.Parse(href)
+	if err != nil {
+		return "#"
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "mailto":
+		return href
+	}
+	return "#"
+}
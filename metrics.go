@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request duration
+// histogram buckets
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// requestKey identifies one series of the request counter
+type requestKey struct {
+	route  string
+	status int
+}
+
+// histogram counts observations into latencyBuckets
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// metricsRegistry holds every metric the wiki exposes on /metrics. It is
+// small and hand-rolled rather than pulling in the Prometheus client library
+type metricsRegistry struct {
+	mu          sync.Mutex
+	requests    map[requestKey]uint64
+	latency     map[string]*histogram
+	storeErrors map[string]uint64
+}
+
+// metrics is the registry shared by the middleware and the store wrapper
+var metrics = &metricsRegistry{
+	requests:    map[requestKey]uint64{},
+	latency:     map[string]*histogram{},
+	storeErrors: map[string]uint64{},
+}
+
+// observeRequest records a finished request against its route
+func (m *metricsRegistry) observeRequest(route string, status int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestKey{route, status}]++
+
+	h, ok := m.latency[route]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(latencyBuckets))}
+		m.latency[route] = h
+	}
+
+	secs := d.Seconds()
+	for i, le := range latencyBuckets {
+		if secs <= le {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += secs
+}
+
+// storeError records a failed store operation
+func (m *metricsRegistry) storeError(op string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.storeErrors[op]++
+}
+
+// writeTo writes every metric to w in the Prometheus text exposition format.
+// Series are sorted so the output is stable between scrapes
+func (m *metricsRegistry) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP wiki_http_requests_total Number of HTTP requests handled, by route and status code.")
+	fmt.Fprintln(w, "# TYPE wiki_http_requests_total counter")
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].status < keys[j].status
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "wiki_http_requests_total{route=%s,status=\"%d\"} %d\n", labelValue(k.route), k.status, m.requests[k])
+	}
+
+	fmt.Fprintln(w, "# HELP wiki_http_request_duration_seconds How long HTTP requests took to handle, by route.")
+	fmt.Fprintln(w, "# TYPE wiki_http_request_duration_seconds histogram")
+	for _, route := range sortedKeys(m.latency) {
+		h := m.latency[route]
+		for i, le := range latencyBuckets {
+			fmt.Fprintf(w, "wiki_http_request_duration_seconds_bucket{route=%s,le=\"%s\"} %d\n", labelValue(route), strconv.FormatFloat(le, 'g', -1, 64), h.buckets[i])
+		}
+		fmt.Fprintf(w, "wiki_http_request_duration_seconds_bucket{route=%s,le=\"+Inf\"} %d\n", labelValue(route), h.count)
+		fmt.Fprintf(w, "wiki_http_request_duration_seconds_sum{route=%s} %s\n", labelValue(route), strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "wiki_http_request_duration_seconds_count{route=%s} %d\n", labelValue(route), h.count)
+	}
+
+	fmt.Fprintln(w, "# HELP wiki_store_errors_total Number of page store operations that failed, by operation.")
+	fmt.Fprintln(w, "# TYPE wiki_store_errors_total counter")
+	for _, op := range sortedKeys(m.storeErrors) {
+		fmt.Fprintf(w, "wiki_store_errors_total{op=%s} %d\n", labelValue(op), m.storeErrors[op])
+	}
+}
+
+// sortedKeys returns the keys of a map keyed by string in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// labelValue quotes a label value, escaping the characters the Prometheus
+// text format requires
+func labelValue(v string) string {
+	v = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+	return `"` + v + `"`
+}
+
+// metricsHandler serves the metrics for a Prometheus scraper
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.writeTo(w)
+}
+
+// instrument is middleware that counts and times every request handled by
+// next. Requests are labelled with the mux pattern they matched, like
+// "GET /view/{title}", rather than the full path, so the number of series
+// stays small
+func instrument(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w}
+
+		next.ServeHTTP(rw, r)
+
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "other"
+		}
+		metrics.observeRequest(route, rw.status, time.Since(start))
+	})
+}
+
+// instrumentedStore wraps a Store and counts the operations that fail. A
+// missing page, or a rename onto one that already exists, is an expected
+// outcome and isn't counted
+type instrumentedStore struct {
+	Store
+}
+
+// countError records err against op unless it is nil or one of the expected
+// outcomes above. A request that was cancelled isn't the store's fault either
+func countError(op string, err error) error {
+	if err != nil && !errors.Is(err, os.ErrNotExist) && !errors.Is(err, os.ErrExist) && !errors.Is(err, context.Canceled) {
+		metrics.storeError(op)
+	}
+	return err
+}
+
+// Load loads the page from the wrapped Store
+func (s instrumentedStore) Load(ctx context.Context, title string) (*Page, error) {
+	p, err := s.Store.Load(ctx, title)
+	return p, countError("load", err)
+}
+
+// Save saves the page to the wrapped Store
+func (s instrumentedStore) Save(ctx context.Context, p *Page) error {
+	return countError("save", s.Store.Save(ctx, p))
+}
+
+// Delete deletes the page from the wrapped Store
+func (s instrumentedStore) Delete(ctx context.Context, title string) error {
+	return countError("delete", s.Store.Delete(ctx, title))
+}
+
+// Restore restores the page in the wrapped Store
+func (s instrumentedStore) Restore(ctx context.Context, title string) error {
+	return countError("restore", s.Store.Restore(ctx, title))
+}
+
+// Purge purges the page from the wrapped Store
+func (s instrumentedStore) Purge(ctx context.Context, title string) error {
+	return countError("purge", s.Store.Purge(ctx, title))
+}
+
+// ListTrash lists the trashed pages in the wrapped Store
+func (s instrumentedStore) ListTrash(ctx context.Context) ([]string, error) {
+	titles, err := s.Store.ListTrash(ctx)
+	return titles, countError("list_trash", err)
+}
+
+// Rename renames the page in the wrapped Store
+func (s instrumentedStore) Rename(ctx context.Context, oldTitle, newTitle string) error {
+	return countError("rename", s.Store.Rename(ctx, oldTitle, newTitle))
+}
+
+// List lists the pages in the wrapped Store
+func (s instrumentedStore) List(ctx context.Context) ([]string, error) {
+	titles, err := s.Store.List(ctx)
+	return titles, countError("list", err)
+}
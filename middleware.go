@@ -0,0 +1,330 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logFormat selects how logRequests writes each line: "text" for the
+// standard log format or "json" for one JSON object per line
+var logFormat = "text"
+
+// requestIDHeader is the response header carrying each request's ID
+const requestIDHeader = "X-Request-ID"
+
+// requestIDKey is the context key the request ID is stored under
+type requestIDKey struct{}
+
+// requestIDs is middleware that gives every request a random ID. It is sent
+// back in the X-Request-ID header and written to the request log, so an ID a
+// user reports can be matched up with what the server logged
+func requestIDs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := make([]byte, 8)
+		rand.Read(b)
+		id := hex.EncodeToString(b)
+
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the ID requestIDs gave the request
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// serverError logs err along with the request's ID and answers 500 with a
+// generic message, so internal details like file paths never reach the
+// client. The ID is read back from the response header, which lets helpers
+// that only have the ResponseWriter use it too
+func serverError(w http.ResponseWriter, err error) {
+	id := w.Header().Get(requestIDHeader)
+	log.Printf("request %s failed: %v", id, err)
+	http.Error(w, "internal error, quote request ID "+id+" when reporting it", http.StatusInternalServerError)
+}
+
+// recoverPanics is middleware that stops a panicking handler from taking the
+// connection down with it. The panic and its stack trace are logged and the
+// client gets a 500, or if the response had already started, just the end of
+// it. http.ErrAbortHandler is the way to deliberately abort a response, so it
+// is passed on
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			err := fmt.Errorf("panic: %v\n%s", v, debug.Stack())
+			if rw.status != 0 {
+				log.Printf("request %s failed after the response started: %v", w.Header().Get(requestIDHeader), err)
+				return
+			}
+			serverError(w, err)
+		}()
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// requestLogEntry is a single request as it is written in the json format
+type requestLogEntry struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	DurationMS float64   `json:"duration_ms"`
+	Bytes      int       `json:"bytes"`
+}
+
+// jsonLogMu stops json log lines from concurrent requests interleaving
+var jsonLogMu sync.Mutex
+
+// responseWriter wraps an http.ResponseWriter and remembers the status code
+// and number of bytes written, so middleware can report on them afterwards
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+// WriteHeader records the status code before passing it on
+func (rw *responseWriter) WriteHeader(status int) {
+	rw.status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap returns the wrapped writer, so an http.ResponseController can reach
+// the features of the one underneath like flushing
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Write counts the bytes written. A handler that writes without calling
+// WriteHeader first implicitly sends 200 OK
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += n
+	return n, err
+}
+
+// logRequests is middleware that logs the method, path, status code and
+// duration of every request handled by next
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w}
+
+		next.ServeHTTP(rw, r)
+
+		// A handler that never wrote anything still sent a 200
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+		duration := time.Since(start)
+
+		if logFormat != "json" {
+			log.Printf("%s %s %d %s %s", r.Method, r.URL.Path, rw.status, duration, requestID(r))
+			return
+		}
+
+		// Written straight to the log's output, since the usual timestamp
+		// prefix would stop the line being valid JSON
+		jsonLogMu.Lock()
+		defer jsonLogMu.Unlock()
+		json.NewEncoder(log.Writer()).Encode(requestLogEntry{
+			Time:       start.UTC(),
+			RequestID:  requestID(r),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Status:     rw.status,
+			DurationMS: float64(duration) / float64(time.Millisecond),
+			Bytes:      rw.bytes,
+		})
+	})
+}
+
+// minGzipSize is the smallest response body, in bytes, worth compressing.
+// Below this the gzip header and footer outweigh any saving
+const minGzipSize = 1024
+
+// gzipResponses is middleware that compresses responses from next with gzip
+// when the client accepts it. Small bodies and content types that are already
+// compressed, like images and archives, are sent as they are
+func gzipResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Caches have to keep compressed and plain copies apart
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		// A HEAD response has no body to compress, and its Content-Length
+		// has to be that of the plain body GET would send
+		if r.Method == http.MethodHead || !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The stream is only ended when the handler returns. After a panic
+		// it is left unfinished, so the client can tell the body was cut
+		// short, and if nothing was sent yet recoverPanics can still answer
+		// with a clean 500
+		gw := &gzipResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(gw, r)
+		gw.finish()
+	})
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding header allows a
+// gzip response
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(enc, ";")
+		name := strings.TrimSpace(parts[0])
+		if name != "gzip" && name != "*" {
+			continue
+		}
+
+		// "gzip;q=0" explicitly refuses it
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); strings.HasPrefix(param, "q=") && err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter holds back the first minGzipSize bytes of a response so
+// it can decide whether compressing it is worthwhile before anything is sent
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+// WriteHeader records the status code, which is only sent once the writer
+// knows whether the body will be compressed
+func (gw *gzipResponseWriter) WriteHeader(status int) {
+	if !gw.decided {
+		gw.status = status
+	}
+}
+
+// Write buffers the body until there is enough of it to decide on
+// compression, then passes it through
+func (gw *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !gw.decided {
+		gw.buf = append(gw.buf, b...)
+		if len(gw.buf) < minGzipSize {
+			return len(b), nil
+		}
+		if err := gw.decide(); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	if gw.gz != nil {
+		return gw.gz.Write(b)
+	}
+	return gw.ResponseWriter.Write(b)
+}
+
+// decide works out whether to compress the response, sends the headers and
+// flushes anything buffered so far
+func (gw *gzipResponseWriter) decide() error {
+	gw.decided = true
+	h := gw.Header()
+
+	// Without this net/http would sniff the compressed bytes and guess wrong
+	if h.Get("Content-Type") == "" && len(gw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(gw.buf))
+	}
+
+	// The byte range of a partial response counts bytes of the plain body,
+	// which compressing it would break
+	if len(gw.buf) >= minGzipSize && h.Get("Content-Encoding") == "" && gw.status != http.StatusPartialContent && compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		gw.ResponseWriter.WriteHeader(gw.status)
+		gw.gz = gzip.NewWriter(gw.ResponseWriter)
+		_, err := gw.gz.Write(gw.buf)
+		gw.buf = nil
+		return err
+	}
+
+	gw.ResponseWriter.WriteHeader(gw.status)
+	_, err := gw.ResponseWriter.Write(gw.buf)
+	gw.buf = nil
+	return err
+}
+
+// FlushError sends everything written so far, and is what an
+// http.ResponseController calls to flush. A response flushed before there was
+// enough of it to decide on is sent uncompressed, which suits streams like
+// server-sent events that flush each small piece as they go
+func (gw *gzipResponseWriter) FlushError() error {
+	if !gw.decided {
+		if err := gw.decide(); err != nil {
+			return err
+		}
+	}
+	if gw.gz != nil {
+		if err := gw.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(gw.ResponseWriter).Flush()
+}
+
+// Unwrap returns the wrapped writer, for an http.ResponseController
+func (gw *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
+
+// finish sends a response too small to have been decided on yet and ends the
+// gzip stream if there is one
+func (gw *gzipResponseWriter) finish() {
+	if !gw.decided {
+		gw.decide()
+	}
+	if gw.gz != nil {
+		gw.gz.Close()
+	}
+}
+
+// compressible reports whether a content type is worth compressing. Most
+// images, audio, video and archives are compressed already
+func compressible(contentType string) bool {
+	for _, prefix := range []string{"image/", "audio/", "video/", "application/zip", "application/gzip", "application/x-gzip"} {
+		if strings.HasPrefix(contentType, prefix) {
+			return strings.HasPrefix(contentType, "image/svg")
+		}
+	}
+	return true
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGzipResponses(t *testing.T) {
+	useTempWiki(t)
+	body := strings.Repeat("a line of page text that compresses well\n", 100)
+	savePage(t, "Big", body)
+	savePage(t, "Small", "tiny")
+
+	r := httptest.NewRequest(http.MethodGet, "/raw/Big", nil)
+	r.Header.Set("Accept-Encoding", "gzip, deflate")
+	w := serve(r)
+
+	if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != body {
+		t.Errorf("decompressed body has %d bytes, want the page's %d", len(got), len(body))
+	}
+
+	// Responses too small to be worth it are sent as they are
+	r = httptest.NewRequest(http.MethodGet, "/raw/Small", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w = serve(r)
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("small response has Content-Encoding %q", got)
+	}
+	if w.Body.String() != "tiny" {
+		t.Errorf("small response body = %q, want %q", w.Body.String(), "tiny")
+	}
+
+	// So are responses to clients that didn't ask for gzip
+	w = serve(httptest.NewRequest(http.MethodGet, "/raw/Big", nil))
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("response without Accept-Encoding has Content-Encoding %q", got)
+	}
+}
+
+func TestGzipLeavesHeadAlone(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Big", strings.Repeat("a line of page text that compresses well\n", 100))
+
+	for _, path := range []string{"/raw/Big", "/view/Big"} {
+		get := httptest.NewRequest(http.MethodGet, path, nil)
+		plain := serve(get)
+
+		r := httptest.NewRequest(http.MethodHead, path, nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := serve(r)
+		if got := w.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("HEAD %s: Content-Encoding = %q, want none", path, got)
+		}
+		if got, want := w.Header().Get("Content-Length"), strconv.Itoa(plain.Body.Len()); got != want {
+			t.Errorf("HEAD %s: Content-Length = %q, want the plain body's %s", path, got, want)
+		}
+	}
+}
+
+func TestGzipAfterPanic(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/early", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "not enough to be sent yet")
+		panic("deliberate")
+	})
+	mux.HandleFunc("/late", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("x", 2*minGzipSize))
+		panic("deliberate")
+	})
+	srv := httptest.NewServer(recoverPanics(requestIDs(gzipResponses(mux))))
+	defer srv.Close()
+
+	get := func(path string) *http.Response {
+		t.Helper()
+		r, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// Nothing had been sent, so the client gets a clean, plain 500
+	resp := get("/early")
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError || resp.Header.Get("Content-Encoding") != "" {
+		t.Errorf("panic before sending: %d with Content-Encoding %q", resp.StatusCode, resp.Header.Get("Content-Encoding"))
+	}
+	if !strings.HasPrefix(string(body), "internal error") {
+		t.Errorf("panic before sending: body = %q", body)
+	}
+
+	// Once the response had started, its gzip stream is left unfinished
+	// rather than ended as if the body were whole
+	resp = get("/late")
+	defer resp.Body.Close()
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(zr); err == nil {
+		t.Error("the body cut short by the panic reads as a whole gzip stream")
+	}
+}
+
+func TestJSONRequestLog(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Logged", "body")
+
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(io.Discard)
+	old := logFormat
+	logFormat = "json"
+	defer func() { logFormat = old }()
+
+	serve(httptest.NewRequest(http.MethodGet, "/raw/Logged", nil))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("logged %d lines, want 1: %q", len(lines), out.String())
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("log line isn't JSON: %v: %s", err, lines[0])
+	}
+
+	want := map[string]interface{}{"method": "GET", "path": "/raw/Logged", "status": float64(200), "bytes": float64(4)}
+	for field, value := range want {
+		if entry[field] != value {
+			t.Errorf("%s = %v, want %v", field, entry[field], value)
+		}
+	}
+	for _, field := range []string{"time", "duration_ms", "request_id"} {
+		if _, ok := entry[field]; !ok {
+			t.Errorf("no %s field in %s", field, lines[0])
+		}
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(io.Discard)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/boom", func(w http.ResponseWriter, r *http.Request) {
+		panic("deliberate")
+	})
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "still up")
+	})
+	srv := httptest.NewServer(recoverPanics(requestIDs(mux)))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/boom")
+	if err != nil {
+		t.Fatalf("the panic took the connection down: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusInternalServerError)
+	}
+	if logged := out.String(); !strings.Contains(logged, "panic: deliberate") || !strings.Contains(logged, "goroutine") {
+		t.Errorf("the panic and its stack weren't logged: %q", logged)
+	}
+
+	// The server goes on answering
+	resp, err = http.Get(srv.URL + "/ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "still up" {
+		t.Errorf("after the panic: %d %q", resp.StatusCode, body)
+	}
+}
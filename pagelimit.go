@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// maxPages caps how many pages each wiki can hold, so a public instance
+// can't grow without bound. Pages that already exist can always be edited.
+// 0 means there is no cap
+var maxPages = 0
+
+// pageSet keeps the titles of each wiki's pages, so checking the cap doesn't
+// mean listing the whole wiki on every save. A wiki's titles are listed the
+// first time they are needed and kept up to date as pages change from then on
+type pageSet struct {
+	mu     sync.Mutex
+	byWiki map[string]map[string]bool
+}
+
+// pageCount is the set of titles maxPages is checked against
+var pageCount = &pageSet{byWiki: map[string]map[string]bool{}}
+
+// Keeps the sets up to date as pages are created and removed
+func init() {
+	changeHooks = append(changeHooks, pageCount.changed)
+}
+
+// changed records whether the page with the given title exists after a
+// change to it. A wiki that hasn't been counted yet is left alone
+func (ps *pageSet) changed(title string) {
+	wiki, _ := splitWiki(title)
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	titles, ok := ps.byWiki[wiki]
+	if !ok {
+		return
+	}
+	if pageExists(context.Background(), title) {
+		titles[title] = true
+	} else {
+		delete(titles, title)
+	}
+}
+
+// count returns the number of pages in the request's wiki
+func (ps *pageSet) count(ctx context.Context) (int, error) {
+	wiki := wikiFromContext(ctx)
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if titles, ok := ps.byWiki[wiki]; ok {
+		return len(titles), nil
+	}
+
+	list, err := store.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	titles := make(map[string]bool, len(list))
+	for _, title := range list {
+		titles[title] = true
+	}
+	ps.byWiki[wiki] = titles
+	return len(titles), nil
+}
+
+// checkRoom returns an error if the page with the given title doesn't exist
+// yet and its wiki already holds maxPages. Saves that race each other for the
+// last free spot can take a wiki just past the cap, which is close enough for
+// keeping its growth in check
+func checkRoom(ctx context.Context, title string) error {
+	if maxPages <= 0 || pageExists(ctx, title) {
+		return nil
+	}
+
+	// Failing to count is no reason to refuse the save, which would most
+	// likely fail the same way and report it properly
+	n, err := pageCount.count(ctx)
+	if err != nil {
+		log.Printf("could not count pages: %v", err)
+		return nil
+	}
+	if n >= maxPages {
+		return fmt.Errorf("this wiki already has the most pages it can hold (%d), new pages can't be created until some are deleted", maxPages)
+	}
+	return nil
+}
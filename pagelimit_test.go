@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// useMaxPages caps each wiki at n pages for the length of the test
+func useMaxPages(t *testing.T, n int) {
+	t.Helper()
+
+	old := maxPages
+	maxPages = n
+	t.Cleanup(func() { maxPages = old })
+}
+
+func TestMaxPages(t *testing.T) {
+	useTempWiki(t)
+	useMaxPages(t, 2)
+
+	save := func(title string) int {
+		return serve(postForm("/save/"+title, url.Values{"body": {title}})).Code
+	}
+
+	// Filling the wiki up to the cap works
+	for _, title := range []string{"One", "Two"} {
+		if code := save(title); code != http.StatusFound {
+			t.Fatalf("saving %s: status = %d, want %d", title, code, http.StatusFound)
+		}
+	}
+
+	// One more is refused
+	w := serve(postForm("/save/Three", url.Values{"body": {"three"}}))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("saving past the cap: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if !strings.Contains(w.Body.String(), "most pages it can hold (2)") {
+		t.Errorf("saving past the cap: body = %q", w.Body.String())
+	}
+	if pageExists(context.Background(), "Three") {
+		t.Errorf("the page past the cap was created")
+	}
+
+	// Pages that exist can still be edited at the cap
+	if code := save("One"); code != http.StatusFound {
+		t.Errorf("editing at the cap: status = %d, want %d", code, http.StatusFound)
+	}
+
+	// Deleting a page makes room again
+	if w := serve(postForm("/delete/Two", nil)); w.Code != http.StatusFound {
+		t.Fatalf("deleting: status = %d", w.Code)
+	}
+	if code := save("Three"); code != http.StatusFound {
+		t.Errorf("saving after a delete: status = %d, want %d", code, http.StatusFound)
+	}
+}
+
+func TestMaxPagesOff(t *testing.T) {
+	useTempWiki(t)
+	useMaxPages(t, 0)
+
+	for _, title := range []string{"One", "Two", "Three"} {
+		if w := serve(postForm("/save/"+title, url.Values{"body": {title}})); w.Code != http.StatusFound {
+			t.Errorf("saving %s without a cap: status = %d, want %d", title, w.Code, http.StatusFound)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimit is how many changes per second each client may make once its
+// burst is used up. 0 turns rate limiting off
+var rateLimit = 1.0
+
+// rateBurst is how many changes a client may make in quick succession before
+// rateLimit kicks in
+var rateBurst = 20
+
+// trustProxy makes the rate limiter identify clients by the X-Forwarded-For
+// header instead of the connection's address. Only turn it on behind a proxy
+// that sets the header, otherwise clients can pick their own address
+var trustProxy = false
+
+// bucket is the token bucket of a single client
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter hands out tokens to each client at rateLimit per second, up to
+// rateBurst at a time
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// limiter is the rate limiter shared by every route limitRate wraps
+var limiter = &rateLimiter{buckets: map[string]*bucket{}}
+
+// allow takes a token from the client's bucket. When the bucket is empty it
+// returns false along with how long until the next token is available
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: float64(rateBurst), last: now}
+		l.buckets[client] = b
+	}
+
+	// Tops the bucket up for the time since the client was last seen
+	b.tokens = math.Min(float64(rateBurst), b.tokens+now.Sub(b.last).Seconds()*rateLimit)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / rateLimit * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// cleanup forgets the clients whose buckets have had time to fill back up,
+// since they'd start off full anyway. It stops the map growing with every
+// address that has ever made a change
+func (l *rateLimiter) cleanup(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	full := time.Duration(float64(rateBurst) / rateLimit * float64(time.Second))
+	for client, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// cleanupLoop runs cleanup every interval for as long as the server runs
+func (l *rateLimiter) cleanupLoop(interval time.Duration) {
+	for now := range time.Tick(interval) {
+		l.cleanup(now)
+	}
+}
+
+// clientIP returns the address the rate limiter identifies a request's client
+// by. Behind a trusted proxy that is the last address in X-Forwarded-For, the
+// one the proxy itself added, since anything before it came from the client
+func clientIP(r *http.Request) string {
+	if trustProxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			parts := strings.Split(fwd, ",")
+			if ip := strings.TrimSpace(parts[len(parts)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// limitRate is middleware that answers 429 Too Many Requests, with a
+// Retry-After header, to clients making changes faster than rateLimit allows.
+// Reads are never limited, so only requests that change something use up
+// tokens
+func limitRate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rateLimit <= 0 || r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ok, wait := limiter.allow(clientIP(r), time.Now())
+		if !ok {
+			// Retry-After is in whole seconds, rounded up so a client that
+			// honours it isn't turned away again
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "too many changes, slow down and try again shortly", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
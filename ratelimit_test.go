@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// useRateLimit turns rate limiting on with the given rate and burst, and a
+// limiter that has seen no clients, for the length of the test
+func useRateLimit(t *testing.T, rate float64, burst int) {
+	t.Helper()
+
+	oldRate, oldBurst, oldLimiter := rateLimit, rateBurst, limiter
+	rateLimit, rateBurst = rate, burst
+	limiter = &rateLimiter{buckets: map[string]*bucket{}}
+	t.Cleanup(func() { rateLimit, rateBurst, limiter = oldRate, oldBurst, oldLimiter })
+}
+
+func TestRateLimitTrips(t *testing.T) {
+	useTempWiki(t)
+	useRateLimit(t, 0.5, 3)
+
+	for i := 0; i < 3; i++ {
+		if w := serve(postForm("/save/Busy", url.Values{"body": {"body"}})); w.Code != http.StatusFound {
+			t.Fatalf("save %d within the burst: status = %d, want %d", i+1, w.Code, http.StatusFound)
+		}
+	}
+
+	w := serve(postForm("/save/Busy", url.Values{"body": {"body"}}))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("save past the burst: status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+
+	// One token comes back every two seconds
+	retry, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || retry < 1 || retry > 2 {
+		t.Errorf("Retry-After = %q, want 1 or 2 seconds", w.Header().Get("Retry-After"))
+	}
+
+	// Another client has a bucket of its own
+	r := postForm("/save/Busy", url.Values{"body": {"body"}})
+	r.RemoteAddr = "192.0.2.99:1234"
+	if w := serve(r); w.Code != http.StatusFound {
+		t.Errorf("save from another client: status = %d, want %d", w.Code, http.StatusFound)
+	}
+}
+
+func TestRateLimitRefills(t *testing.T) {
+	useRateLimit(t, 1, 1)
+	now := time.Now()
+
+	if ok, _ := limiter.allow("client", now); !ok {
+		t.Fatal("first change was refused")
+	}
+	if ok, wait := limiter.allow("client", now); ok || wait != time.Second {
+		t.Errorf("second change at once = %v, %v, want refused for 1s", ok, wait)
+	}
+	if ok, _ := limiter.allow("client", now.Add(time.Second)); !ok {
+		t.Error("change a second later was refused")
+	}
+}
+
+func TestClientIPBehindProxy(t *testing.T) {
+	r := postForm("/save/Foo", nil)
+	r.RemoteAddr = "10.0.0.1:5000"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7, 198.51.100.2")
+
+	if got := clientIP(r); got != "10.0.0.1" {
+		t.Errorf("without -trust-proxy clientIP = %q, want the connection's address", got)
+	}
+
+	old := trustProxy
+	trustProxy = true
+	defer func() { trustProxy = old }()
+	if got := clientIP(r); got != "198.51.100.2" {
+		t.Errorf("with -trust-proxy clientIP = %q, want the address the proxy added", got)
+	}
+}
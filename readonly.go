@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// readOnly freezes the wiki, so pages can still be read but not changed. It
+// starts out as -readonly and can be flipped at runtime through
+// /admin/readonly, for instance around a backup
+var readOnly atomic.Bool
+
+// errReadOnlyMessage is what every change refused while the wiki is read-only
+// is answered with
+const errReadOnlyMessage = "wiki is temporarily read-only, try again later"
+
+// isReadOnly reports whether the wiki is read-only, for the templates
+func isReadOnly() bool {
+	return readOnly.Load()
+}
+
+// rejectWhenReadOnly is middleware that answers 503 Service Unavailable to
+// requests that would change something while the wiki is read-only. Reads,
+// and the preflight of a cross-origin API request, go through as usual
+func rejectWhenReadOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !readOnly.Load() || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// API clients expect JSON even for errors
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			writeJSONError(w, http.StatusServiceUnavailable, errReadOnlyMessage)
+			return
+		}
+		http.Error(w, errReadOnlyMessage, http.StatusServiceUnavailable)
+	})
+}
+
+// readOnlyAdminHandler serves /admin/readonly. GET reports whether the wiki is
+// read-only, PUT makes it read-only and DELETE makes it writable again
+func readOnlyAdminHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPut:
+		readOnly.Store(true)
+	case http.MethodDelete:
+		readOnly.Store(false)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"readonly": readOnly.Load()})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// useReadOnly freezes the wiki for the length of the test
+func useReadOnly(t *testing.T) {
+	t.Helper()
+
+	old := readOnly.Load()
+	readOnly.Store(true)
+	t.Cleanup(func() { readOnly.Store(old) })
+}
+
+func TestReadOnlyRefusesWrites(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Foo", "foo\n")
+	useReadOnly(t)
+
+	for _, r := range []*http.Request{
+		postForm("/save/Foo", url.Values{"body": {"changed"}}),
+		postForm("/save/New", url.Values{"body": {"new"}}),
+		postForm("/delete/Foo", nil),
+		postForm("/rename/Foo", url.Values{"newtitle": {"Bar"}}),
+		postForm("/lock/Foo", url.Values{"locked": {"true"}}),
+	} {
+		w := serve(r)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("POST %s: status = %d, want %d", r.URL.Path, w.Code, http.StatusServiceUnavailable)
+		}
+		if !strings.Contains(w.Body.String(), errReadOnlyMessage) {
+			t.Errorf("POST %s: body = %q", r.URL.Path, w.Body.String())
+		}
+	}
+
+	// The API answers in JSON
+	w := serve(httptest.NewRequest(http.MethodPut, "/api/pages/Foo", strings.NewReader(`{"body":"changed"}`)))
+	var resp map[string]string
+	if w.Code != http.StatusServiceUnavailable || json.Unmarshal(w.Body.Bytes(), &resp) != nil || resp["error"] != errReadOnlyMessage {
+		t.Errorf("PUT /api/pages/Foo: %d %s", w.Code, w.Body.String())
+	}
+
+	// Nothing changed
+	if p, err := loadPage("Foo"); err != nil || string(p.Body) != "foo\n" || p.Meta.Locked {
+		t.Errorf("the page changed while read-only: %v, %v", p, err)
+	}
+	if pageExists(context.Background(), "New") || pageExists(context.Background(), "Bar") {
+		t.Errorf("a page was created while read-only")
+	}
+}
+
+func TestReadOnlyAllowsReads(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Foo", "foo\n")
+	useReadOnly(t)
+
+	for _, path := range []string{"/view/Foo", "/raw/Foo", "/index", "/history/Foo", "/api/pages/Foo", "/search?q=foo"} {
+		if w := serve(httptest.NewRequest(http.MethodGet, path, nil)); w.Code != http.StatusOK {
+			t.Errorf("GET %s: status = %d, want %d", path, w.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestReadOnlyAdminToggle(t *testing.T) {
+	useTempWiki(t)
+	useReadOnly(t)
+	readOnly.Store(false)
+
+	for _, step := range []struct {
+		method string
+		want   bool
+	}{
+		{http.MethodPut, true},
+		{http.MethodGet, true},
+		{http.MethodDelete, false},
+	} {
+		w := serve(httptest.NewRequest(step.method, "/admin/readonly", nil))
+		var resp map[string]bool
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp["readonly"] != step.want {
+			t.Errorf("%s /admin/readonly = %s, want readonly %v", step.method, w.Body.String(), step.want)
+		}
+		if readOnly.Load() != step.want {
+			t.Errorf("after %s the wiki is read-only: %v", step.method, readOnly.Load())
+		}
+	}
+
+	// And saving works again once writable
+	if w := serve(postForm("/save/Foo", url.Values{"body": {"foo"}})); w.Code != http.StatusFound {
+		t.Errorf("saving once writable: status = %d, want %d", w.Code, http.StatusFound)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// recentLimit is how many of the most recently changed pages /recent and
+// /recent.xml list
+var recentLimit = 20
+
+// recentEntry is a page listed on /recent
+type recentEntry struct {
+	Title   string
+	ModTime time.Time
+}
+
+// recentPage is the data rendered by recent.html
+type recentPage struct {
+	Wiki    string
+	Entries []recentEntry
+}
+
+// recentChanges returns the pages of the request's wiki the request may see,
+// most recently changed first, up to recentLimit of them
+func recentChanges(r *http.Request) ([]recentEntry, error) {
+	titles, err := store.List(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []recentEntry
+	for _, title := range titles {
+		p, err := store.Load(r.Context(), title)
+
+		// The page may have been deleted since it was listed
+		if err != nil || !canView(r, p) {
+			continue
+		}
+		entries = append(entries, recentEntry{Title: title, ModTime: p.ModTime})
+	}
+
+	// Pages changed at the same moment are kept in title order
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].ModTime.After(entries[j].ModTime) })
+	if len(entries) > recentLimit {
+		entries = entries[:recentLimit]
+	}
+	return entries, nil
+}
+
+// recentHandler lists the most recently changed pages, newest first, with
+// when each was changed
+func recentHandler(w http.ResponseWriter, r *http.Request) {
+	entries, err := recentChanges(r)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	renderTemplate(w, "recent", recentPage{Wiki: wikiFromContext(r.Context()), Entries: entries})
+}
+
+// atomFeed is the root element of an Atom feed
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	XMLNS   string      `xml:"xmlns,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// atomLink is the link from a feed or one of its entries to the page it is
+// about
+type atomLink struct {
+	Href string `xml:"href,attr"`
+}
+
+// atomEntry is a single changed page in the feed
+type atomEntry struct {
+	ID      string   `xml:"id"`
+	Title   string   `xml:"title"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+}
+
+// recentFeedHandler serves the same list as recentHandler as an Atom feed,
+// for feed readers to subscribe to. Links are absolute, starting with
+// -base-url like the sitemap's
+func recentFeedHandler(w http.ResponseWriter, r *http.Request) {
+	entries, err := recentChanges(r)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	base := baseURL
+	if base == "" {
+		base = requestBaseURL(r)
+	}
+	base = strings.TrimSuffix(base, "/")
+	wiki := wikiFromContext(r.Context())
+
+	// A feed has to say when it was last updated even with nothing in it
+	updated := time.Unix(0, 0)
+	if len(entries) > 0 {
+		updated = entries[0].ModTime
+	}
+
+	feed := atomFeed{
+		XMLNS:   "http://www.w3.org/2005/Atom",
+		ID:      base + wikiPath(wiki, "/recent"),
+		Title:   "Recent changes",
+		Updated: updated.UTC().Format(time.RFC3339),
+		Link:    atomLink{Href: base + wikiPath(wiki, "/recent")},
+	}
+	for _, e := range entries {
+		_, name := splitWiki(e.Title)
+		link := base + pageURL("view", e.Title)
+
+		// Every change to a page is an entry of its own, so the time it was
+		// made is part of the entry's id
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      fmt.Sprintf("%s#%d", link, e.ModTime.UnixNano()),
+			Title:   name,
+			Updated: e.ModTime.UTC().Format(time.RFC3339),
+			Link:    atomLink{Href: link},
+		})
+	}
+
+	out, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	fmt.Fprintf(w, "%s%s\n", xml.Header, out)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// savePagesAt saves a page for each title, with its file last modified at
+// the matching time
+func savePagesAt(t *testing.T, times map[string]time.Time) {
+	t.Helper()
+
+	for title, mod := range times {
+		savePage(t, title, title)
+		if err := os.Chtimes(pageFile(title), mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cache.clear()
+}
+
+func TestRecentOrder(t *testing.T) {
+	useTempWiki(t)
+	base := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	savePagesAt(t, map[string]time.Time{
+		"Old":    base,
+		"Newest": base.Add(2 * time.Hour),
+		"Middle": base.Add(time.Hour),
+		"Tied":   base.Add(time.Hour),
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/recent", nil)
+	entries, err := recentChanges(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Title)
+	}
+
+	// Pages changed at the same moment stay in title order
+	if want := "Newest Middle Tied Old"; strings.Join(got, " ") != want {
+		t.Errorf("recent changes = %v, want %s", got, want)
+	}
+
+	// The page lists them in that order too
+	body := serve(r).Body.String()
+	if i, j := strings.Index(body, "/view/Newest"), strings.Index(body, "/view/Old"); i < 0 || j < 0 || i > j {
+		t.Errorf("/recent doesn't list Newest before Old")
+	}
+}
+
+func TestRecentLimit(t *testing.T) {
+	useTempWiki(t)
+	old := recentLimit
+	recentLimit = 2
+	defer func() { recentLimit = old }()
+
+	base := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	savePagesAt(t, map[string]time.Time{"A": base, "B": base.Add(time.Minute), "C": base.Add(2 * time.Minute)})
+
+	entries, err := recentChanges(httptest.NewRequest(http.MethodGet, "/recent", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Title != "C" || entries[1].Title != "B" {
+		t.Errorf("recent changes = %v, want C and B", entries)
+	}
+}
+
+func TestRecentFeed(t *testing.T) {
+	useTempWiki(t)
+	old := baseURL
+	baseURL = "https://wiki.example.com"
+	defer func() { baseURL = old }()
+
+	older := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	savePagesAt(t, map[string]time.Time{"Older": older, "Newer": newer})
+
+	w := serve(httptest.NewRequest(http.MethodGet, "/recent.xml", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "application/atom+xml; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	var feed atomFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("the feed isn't valid XML: %v", err)
+	}
+	if feed.XMLName.Space != "http://www.w3.org/2005/Atom" || feed.XMLName.Local != "feed" {
+		t.Errorf("root element = %v, want an Atom feed", feed.XMLName)
+	}
+	if feed.ID != "https://wiki.example.com/recent" || feed.Title == "" {
+		t.Errorf("feed id = %q, title = %q", feed.ID, feed.Title)
+	}
+	if feed.Updated != newer.Format(time.RFC3339) {
+		t.Errorf("feed updated = %q, want the newest change %s", feed.Updated, newer.Format(time.RFC3339))
+	}
+
+	if len(feed.Entries) != 2 {
+		t.Fatalf("feed has %d entries, want 2", len(feed.Entries))
+	}
+	e := feed.Entries[0]
+	if e.Title != "Newer" || e.Link.Href != "https://wiki.example.com/view/Newer" || e.Updated != newer.Format(time.RFC3339) {
+		t.Errorf("first entry = %+v", e)
+	}
+	if !strings.HasPrefix(e.ID, e.Link.Href+"#") {
+		t.Errorf("entry id %q isn't the page's link and when it changed", e.ID)
+	}
+}
+
+func TestRecentFeedEmpty(t *testing.T) {
+	useTempWiki(t)
+
+	var feed atomFeed
+	w := serve(httptest.NewRequest(http.MethodGet, "/recent.xml", nil))
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("the feed isn't valid XML: %v", err)
+	}
+	if feed.Updated == "" || len(feed.Entries) != 0 {
+		t.Errorf("empty feed: updated %q with %d entries", feed.Updated, len(feed.Entries))
+	}
+}
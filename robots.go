@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+)
+
+// robotsDisallowed are the routes crawlers are kept out of: the editor and
+// the page histories, which are endless variations on the same content
+var robotsDisallowed = []string{"/edit/", "/history/", "/diff/"}
+
+// defaultRobots returns the rules that let crawlers index every page but the
+// robotsDisallowed routes, under basePath. Crawlers only ever fetch
+// robots.txt from the root of the host, so behind a proxy with -base-path the
+// proxy has to serve <base-path>/robots.txt as /robots.txt
+func defaultRobots() string {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	for _, route := range robotsDisallowed {
+		b.WriteString("Disallow: " + basePath + route + "\n")
+	}
+	return b.String()
+}
+
+// robotsRules is what /robots.txt serves when -robots gives a file to serve
+// instead of the built-in rules. Nil means the built-in rules
+var robotsRules []byte
+
+// robotsHandler serves the robots.txt rules for crawlers
+func robotsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if robotsRules != nil {
+		w.Write(robotsRules)
+		return
+	}
+	io.WriteString(w, defaultRobots())
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNoIndexHeader(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Hidden", "---\nnoindex: true\n---\nbody\n")
+	savePage(t, "Listed", "body\n")
+
+	if got := serve(httptest.NewRequest(http.MethodGet, "/view/Hidden", nil)).Header().Get("X-Robots-Tag"); got != "noindex" {
+		t.Errorf("noindex page: X-Robots-Tag = %q, want noindex", got)
+	}
+	if got := serve(httptest.NewRequest(http.MethodGet, "/view/Listed", nil)).Header().Get("X-Robots-Tag"); got != "" {
+		t.Errorf("ordinary page: X-Robots-Tag = %q, want none", got)
+	}
+
+	// Revalidating crawlers are told too
+	r := httptest.NewRequest(http.MethodGet, "/view/Hidden", nil)
+	r.Header.Set("If-None-Match", serve(httptest.NewRequest(http.MethodGet, "/view/Hidden", nil)).Header().Get("ETag"))
+	w := serve(r)
+	if w.Code != http.StatusNotModified || w.Header().Get("X-Robots-Tag") != "noindex" {
+		t.Errorf("304: status = %d, X-Robots-Tag = %q", w.Code, w.Header().Get("X-Robots-Tag"))
+	}
+}
+
+func TestRobotsTxt(t *testing.T) {
+	w := serve(httptest.NewRequest(http.MethodGet, "/robots.txt", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	body := w.Body.String()
+	for _, line := range []string{"User-agent: *", "Disallow: /edit/", "Disallow: /history/"} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("robots.txt has no %q line:\n%s", line, body)
+		}
+	}
+
+	old := robotsRules
+	robotsRules = []byte("User-agent: *\nDisallow: /\n")
+	defer func() { robotsRules = old }()
+	if got := serve(httptest.NewRequest(http.MethodGet, "/robots.txt", nil)).Body.String(); got != string(robotsRules) {
+		t.Errorf("robots.txt = %q, want the -robots file", got)
+	}
+}
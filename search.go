@@ -0,0 +1,132 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxSearchPages is the most pages a single search will read, so a query on a
+// very large wiki can't tie the server up indefinitely
+var maxSearchPages = 1000
+
+// snippetContext is how many bytes of text either side of a match are shown
+// in a search result
+const snippetContext = 60
+
+// searchResult is a single page matching a search
+type searchResult struct {
+	Title   string
+	Snippet template.HTML
+}
+
+// searchPage is the data rendered by search.html
+type searchPage struct {
+	Wiki    string
+	Query   string
+	Results []searchResult
+
+	// Truncated is set when the wiki had more than maxSearchPages pages and
+	// only the first of them were searched
+	Truncated bool
+}
+
+// searchHandler looks for pages whose title or body contains the q query
+// parameter, ignoring case, and lists them with a snippet around the first
+// match in the body
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	data := searchPage{Wiki: wikiFromContext(r.Context()), Query: query}
+
+	// An empty query just shows the search box
+	if query == "" {
+		renderTemplate(w, "search", data)
+		return
+	}
+
+	titles, err := store.List(r.Context())
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	if len(titles) > maxSearchPages {
+		titles = titles[:maxSearchPages]
+		data.Truncated = true
+	}
+
+	// The query is matched literally, QuoteMeta stops it being treated as a
+	// regular expression
+	pattern := regexp.MustCompile("(?i)" + regexp.QuoteMeta(query))
+
+	for _, title := range titles {
+		// Stops reading pages once the client has gone away
+		if r.Context().Err() != nil {
+			return
+		}
+
+		p, err := store.Load(r.Context(), title)
+
+		// The page may have been deleted since the directory was scanned, and
+		// private pages only turn up for those who could view them
+		if err != nil || !canView(r, p) {
+			continue
+		}
+
+		loc := pattern.FindIndex(p.Body)
+		if _, name := splitWiki(title); loc == nil && !pattern.MatchString(name) {
+			continue
+		}
+
+		data.Results = append(data.Results, searchResult{Title: title, Snippet: snippet(p.Body, loc)})
+	}
+
+	renderTemplate(w, "search", data)
+}
+
+// snippet returns the text of body around the match at loc, with the match
+// itself wrapped in <mark>. Every piece of the body is escaped, so the result
+// is safe to display even though it is template.HTML. A nil loc means only
+// the title matched, in which case the start of the body is shown
+func snippet(body []byte, loc []int) template.HTML {
+	if loc == nil {
+		end := runeBoundary(body, snippetContext*2)
+		text := template.HTMLEscapeString(string(body[:end]))
+		if end < len(body) {
+			text += "…"
+		}
+		return template.HTML(text)
+	}
+
+	start := runeBoundary(body, loc[0]-snippetContext)
+	end := runeBoundary(body, loc[1]+snippetContext)
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	b.WriteString(template.HTMLEscapeString(string(body[start:loc[0]])))
+	b.WriteString("<mark>" + template.HTMLEscapeString(string(body[loc[0]:loc[1]])) + "</mark>")
+	b.WriteString(template.HTMLEscapeString(string(body[loc[1]:end])))
+	if end < len(body) {
+		b.WriteString("…")
+	}
+	return template.HTML(b.String())
+}
+
+// runeBoundary clamps i to the bounds of body and moves it back to the start
+// of a UTF-8 character, so slicing at it never splits a multibyte rune
+func runeBoundary(body []byte, i int) int {
+	if i <= 0 {
+		return 0
+	}
+	if i >= len(body) {
+		return len(body)
+	}
+	for i > 0 && !utf8.RuneStart(body[i]) {
+		i--
+	}
+	return i
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSecureHeaders(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Foo", "body")
+
+	want := map[string]string{
+		"X-Content-Type-Options":  "nosniff",
+		"Content-Security-Policy": defaultCSP,
+		"X-Frame-Options":         "DENY",
+	}
+
+	// Views, raw pages and errors all carry them
+	for _, path := range []string{"/view/Foo", "/raw/Foo", "/nowhere"} {
+		w := serve(httptest.NewRequest(http.MethodGet, path, nil))
+		for header, value := range want {
+			if got := w.Header().Get(header); got != value {
+				t.Errorf("GET %s: %s = %q, want %q", path, header, got, value)
+			}
+		}
+	}
+}
+
+func TestSecureHeadersConfigurable(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Foo", "body")
+
+	oldCSP, oldFrame := contentSecurityPolicy, frameOptions
+	defer func() { contentSecurityPolicy, frameOptions = oldCSP, oldFrame }()
+
+	contentSecurityPolicy, frameOptions = "default-src 'none'", ""
+	w := serve(httptest.NewRequest(http.MethodGet, "/view/Foo", nil))
+	if got := w.Header().Get("Content-Security-Policy"); got != "default-src 'none'" {
+		t.Errorf("Content-Security-Policy = %q, want the one set", got)
+	}
+	if _, ok := w.Header()["X-Frame-Options"]; ok {
+		t.Errorf("X-Frame-Options was sent when turned off")
+	}
+	if got := w.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("X-Content-Type-Options = %q, want nosniff", got)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// baseURL is the scheme and host, like https://wiki.example.com, that
+// sitemap locations start with. When unset it is worked out from each request
+var baseURL string
+
+// sitemapURLSet is the root element of a sitemap
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// sitemapURL is a single page's entry in the sitemap
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// validateBaseURL checks that -base-url is an absolute http or https URL with
+// nothing after the host but an optional path
+func validateBaseURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("it must start with http:// or https://")
+	}
+	if u.Host == "" {
+		return errors.New("it must include a host")
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return errors.New("it cannot have a query or fragment")
+	}
+	return nil
+}
+
+// requestBaseURL is the base URL the request was made to, used when
+// -base-url isn't set
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// sitemapHandler lists every page for search engines. Pages that are private
+// or marked noindex are left out, since a crawler couldn't or shouldn't index
+// them anyway
+func sitemapHandler(w http.ResponseWriter, r *http.Request) {
+	titles, err := store.List(r.Context())
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	base := baseURL
+	if base == "" {
+		base = requestBaseURL(r)
+	}
+	base = strings.TrimSuffix(base, "/")
+
+	set := sitemapURLSet{XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	for _, title := range titles {
+		p, err := store.Load(r.Context(), title)
+
+		// The page may have been deleted since it was listed
+		if err != nil || p.Meta.Private || p.Meta.NoIndex || p.Expired(time.Now()) {
+			continue
+		}
+
+		set.URLs = append(set.URLs, sitemapURL{
+			Loc:     base + pageURL("view", title),
+			LastMod: p.ModTime.UTC().Format(time.RFC3339),
+		})
+	}
+
+	out, err := xml.MarshalIndent(set, "", "  ")
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	fmt.Fprintf(w, "%s%s\n", xml.Header, out)
+}
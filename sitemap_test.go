@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSitemap(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Foo", "foo")
+	savePage(t, "Bar", "bar")
+	savePage(t, "Hidden", "---\nprivate: true\n---\nsecret\n")
+	savePage(t, "Unlisted", "---\nnoindex: true\n---\nunlisted\n")
+
+	old := baseURL
+	baseURL = "https://wiki.example.com/"
+	defer func() { baseURL = old }()
+
+	w := serve(httptest.NewRequest(http.MethodGet, "/sitemap.xml", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/xml") {
+		t.Errorf("Content-Type = %q, want application/xml", ct)
+	}
+	if !strings.HasPrefix(w.Body.String(), xml.Header) {
+		t.Errorf("the sitemap doesn't start with an XML declaration")
+	}
+
+	var set sitemapURLSet
+	if err := xml.Unmarshal(w.Body.Bytes(), &set); err != nil {
+		t.Fatalf("the sitemap isn't valid XML: %v", err)
+	}
+	if set.XMLName.Local != "urlset" || set.XMLNS != "http://www.sitemaps.org/schemas/sitemap/0.9" {
+		t.Errorf("root element = %v with xmlns %q", set.XMLName, set.XMLNS)
+	}
+
+	// Private and noindex pages are left out, and the base URL's trailing
+	// slash isn't doubled
+	locs := map[string]bool{}
+	for _, u := range set.URLs {
+		locs[u.Loc] = true
+		if _, err := time.Parse(time.RFC3339, u.LastMod); err != nil {
+			t.Errorf("%s has lastmod %q: %v", u.Loc, u.LastMod, err)
+		}
+	}
+	want := []string{"https://wiki.example.com/view/Foo", "https://wiki.example.com/view/Bar"}
+	if len(locs) != len(want) {
+		t.Errorf("sitemap lists %v, want %v", locs, want)
+	}
+	for _, loc := range want {
+		if !locs[loc] {
+			t.Errorf("sitemap is missing %s", loc)
+		}
+	}
+}
+
+func TestSitemapBaseURLFromRequest(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Foo", "foo")
+
+	r := httptest.NewRequest(http.MethodGet, "/sitemap.xml", nil)
+	r.Host = "localhost:8080"
+	w := serve(r)
+
+	var set sitemapURLSet
+	if err := xml.Unmarshal(w.Body.Bytes(), &set); err != nil {
+		t.Fatalf("the sitemap isn't valid XML: %v", err)
+	}
+	if len(set.URLs) != 1 || set.URLs[0].Loc != "http://localhost:8080/view/Foo" {
+		t.Errorf("URLs = %+v, want one for http://localhost:8080/view/Foo", set.URLs)
+	}
+}
+
+func TestValidateBaseURL(t *testing.T) {
+	for s, ok := range map[string]bool{
+		"https://wiki.example.com":      true,
+		"http://localhost:8080/wiki":    true,
+		"wiki.example.com":              false,
+		"ftp://wiki.example.com":        false,
+		"https://":                      false,
+		"https://wiki.example.com/?a=b": false,
+		"https://wiki.example.com/#top": false,
+	} {
+		if err := validateBaseURL(s); (err == nil) != ok {
+			t.Errorf("validateBaseURL(%q) = %v", s, err)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+//go:build sqlite
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Makes -store=sqlite available when built with -tags sqlite
+func init() {
+	storeBackends["sqlite"] = func() (Store, error) {
+		return newSQLiteStore(dbPath)
+	}
+}
+
+// sqliteSchema creates the pages table, and the trash table deleted pages are
+// moved into, the first time a database is opened
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS pages (
+		title      TEXT PRIMARY KEY,
+		body       BLOB NOT NULL,
+		updated_at INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS trash (
+		title      TEXT PRIMARY KEY,
+		body       BLOB NOT NULL,
+		updated_at INTEGER NOT NULL
+	)`,
+}
+
+// SQLiteStore is a Store that keeps every page as a row of a SQLite database,
+// which is a single file to back up and copes with concurrent writers itself
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// newSQLiteStore opens (creating if needed) the database at path and makes
+// sure its tables exist
+func newSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stmt := range sqliteSchema {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+// Load reads the page's row
+func (s *SQLiteStore) Load(ctx context.Context, title string) (*Page, error) {
+	var data []byte
+	var updated int64
+
+	err := s.db.QueryRowContext(ctx, "SELECT body, updated_at FROM pages WHERE title = ?", title).Scan(&data, &updated)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("page %q: %w", title, os.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := openPage(data)
+	if err != nil {
+		return nil, fmt.Errorf("page %q: %w", title, err)
+	}
+
+	p := pageFromSource(title, src)
+	p.ModTime = time.Unix(updated, 0)
+	return p, nil
+}
+
+// Save inserts the page's row, or replaces it if the page already exists
+func (s *SQLiteStore) Save(ctx context.Context, p *Page) error {
+	now := time.Now()
+
+	data, err := sealPage(p.Source())
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, `INSERT INTO pages (title, body, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(title) DO UPDATE SET body = excluded.body, updated_at = excluded.updated_at`,
+		p.Title, data, now.Unix())
+	if err != nil {
+		return err
+	}
+
+	p.ModTime = now.Truncate(time.Second)
+	return nil
+}
+
+// Delete moves the page's row into the trash table, unless an earlier page
+// with the same title is already there
+func (s *SQLiteStore) Delete(ctx context.Context, title string) error {
+	return s.move(ctx, "pages", "trash", title)
+}
+
+// Restore moves the page's row from the trash table back into pages
+func (s *SQLiteStore) Restore(ctx context.Context, title string) error {
+	return s.move(ctx, "trash", "pages", title)
+}
+
+// move copies a page's row from one table to the other and removes the
+// original, all in one transaction. Neither table has a row overwritten, so
+// a title already in the destination is an error matching os.ErrExist
+func (s *SQLiteStore) move(ctx context.Context, from, to, title string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The table names only ever come from the two callers above
+	var exists int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+to+" WHERE title = ?", title).Scan(&exists); err != nil {
+		return err
+	}
+	if exists > 0 {
+		return fmt.Errorf("page %q: %w", title, os.ErrExist)
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO "+to+" (title, body, updated_at) SELECT title, body, updated_at FROM "+from+" WHERE title = ?", title)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM "+from+" WHERE title = ?", title)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("page %q: %w", title, os.ErrNotExist)
+	}
+
+	return tx.Commit()
+}
+
+// Purge removes the page's row from the trash table
+func (s *SQLiteStore) Purge(ctx context.Context, title string) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM trash WHERE title = ?", title)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("page %q: %w", title, os.ErrNotExist)
+	}
+	return nil
+}
+
+// Rename changes the title of the page's row. The primary key on title makes
+// the database refuse to overwrite another page, but that is checked first to
+// give a proper error
+func (s *SQLiteStore) Rename(ctx context.Context, oldTitle, newTitle string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM pages WHERE title = ?", newTitle).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists > 0 {
+		return fmt.Errorf("page %q: %w", newTitle, os.ErrExist)
+	}
+
+	res, err := tx.ExecContext(ctx, "UPDATE pages SET title = ? WHERE title = ?", newTitle, oldTitle)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("page %q: %w", oldTitle, os.ErrNotExist)
+	}
+
+	return tx.Commit()
+}
+
+// List returns every title in the pages table
+func (s *SQLiteStore) List(ctx context.Context) ([]string, error) {
+	return s.titles(ctx, "pages")
+}
+
+// ListTrash returns every title in the trash table
+func (s *SQLiteStore) ListTrash(ctx context.Context) ([]string, error) {
+	return s.titles(ctx, "trash")
+}
+
+// titles returns every title in the given table that belongs to the
+// request's wiki, sorted alphabetically
+func (s *SQLiteStore) titles(ctx context.Context, table string) ([]string, error) {
+	wiki := wikiFromContext(ctx)
+
+	rows, err := s.db.QueryContext(ctx, "SELECT title FROM "+table+" ORDER BY title")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	titles := []string{}
+	for rows.Next() {
+		var title string
+		if err := rows.Scan(&title); err != nil {
+			return nil, err
+		}
+		if w, _ := splitWiki(title); w != wiki {
+			continue
+		}
+		titles = append(titles, title)
+	}
+	return titles, rows.Err()
+}
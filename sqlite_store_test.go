@@ -0,0 +1,29 @@
+//go:build sqlite
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		s, err := newSQLiteStore(filepath.Join(t.TempDir(), "wiki.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.db.Close() })
+		return s
+	})
+}
+
+func TestWatcherLeavesSQLiteAlone(t *testing.T) {
+	s, err := newSQLiteStore(filepath.Join(t.TempDir(), "wiki.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.db.Close()
+
+	checkWatcherIgnores(t, s)
+}
@@ -0,0 +1,35 @@
+// Autosaves the page being edited as a draft by posting the textarea's content
+// to /draft/<title> every so often, as long as it has changed since the last
+// successful autosave
+(function () {
+	var body = document.getElementById("body");
+	if (!body || !body.dataset.draft) {
+		return;
+	}
+	var token = body.form.elements.csrf_token.value;
+
+	var saved = body.value;
+	function autosave() {
+		var value = body.value;
+		if (value === saved) {
+			return;
+		}
+
+		fetch(body.dataset.draft, {
+			method: "POST",
+			body: new URLSearchParams({ body: value, csrf_token: token }),
+		}).then(function (res) {
+			if (res.ok) {
+				saved = value;
+			}
+		});
+	}
+
+	setInterval(autosave, 30000);
+
+	// Submitting the form publishes the page, so there is nothing left to
+	// autosave afterwards
+	body.form.addEventListener("submit", function () {
+		saved = body.value;
+	});
+})();
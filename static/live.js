@@ -0,0 +1,14 @@
+// Reloads the page being viewed when someone else changes it, listening to
+// the server-sent events from /events/<title>
+(function () {
+	var page = document.querySelector("[data-events]");
+	if (!page || !window.EventSource) {
+		return;
+	}
+
+	var source = new EventSource(page.dataset.events);
+	source.addEventListener("change", function () {
+		source.close();
+		window.location.reload();
+	});
+})();
@@ -0,0 +1,31 @@
+// Shows a live preview of the page being edited by posting the textarea's
+// content to /preview/<title> shortly after the user stops typing
+(function () {
+	var body = document.getElementById("body");
+	var preview = document.getElementById("preview");
+	if (!body || !preview) {
+		return;
+	}
+
+	var timer;
+	function update() {
+		fetch(body.dataset.preview, {
+			method: "POST",
+			body: new URLSearchParams({ body: body.value }),
+		})
+			.then(function (res) {
+				return res.text();
+			})
+			.then(function (html) {
+				// The server escapes everything in the source, so the fragment
+				// is safe to insert
+				preview.innerHTML = html;
+			});
+	}
+
+	body.addEventListener("input", function () {
+		clearTimeout(timer);
+		timer = setTimeout(update, 300);
+	});
+	update();
+})();
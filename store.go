@@ -0,0 +1,132 @@
+package main
+
+import "context"
+
+// Store is where the wiki keeps its pages. Handlers only ever go through the
+// Store, so the way pages are persisted can be swapped without touching them.
+// Load, Delete, Rename, Restore and Purge return an error matching
+// os.ErrNotExist (via errors.Is) when there is no page with the given title.
+// Every method takes the context of the request it is for, and gives up with
+// the context's error once the request is cancelled or times out
+type Store interface {
+	// Load returns the page with the given title
+	Load(ctx context.Context, title string) (*Page, error)
+
+	// Save creates the page or replaces its current content
+	Save(ctx context.Context, p *Page) error
+
+	// Delete moves the page with the given title to the trash. Pages in the
+	// trash can't be loaded and aren't listed. If an earlier page with the
+	// same title is still in the trash neither is touched and an error
+	// matching os.ErrExist is returned
+	Delete(ctx context.Context, title string) error
+
+	// Restore moves a page out of the trash. If a page with the same title
+	// has been created since it is left alone and an error matching
+	// os.ErrExist is returned
+	Restore(ctx context.Context, title string) error
+
+	// Purge permanently removes a page from the trash
+	Purge(ctx context.Context, title string) error
+
+	// ListTrash returns the titles of every page in the trash, sorted
+	// alphabetically
+	ListTrash(ctx context.Context) ([]string, error)
+
+	// Rename moves a page to a new title. If a page with the new title
+	// already exists it is left alone and an error matching os.ErrExist is
+	// returned
+	Rename(ctx context.Context, oldTitle, newTitle string) error
+
+	// List returns the titles of every page, sorted alphabetically
+	List(ctx context.Context) ([]string, error)
+}
+
+// FileStore is a Store that keeps each page in its own file, named after the
+// page title with fileExt on the end, inside dataDir or the directory of the
+// page's wiki. A single file operation can't be interrupted, so the context
+// is only checked before starting one
+type FileStore struct{}
+
+// Load reads the page's file
+func (FileStore) Load(ctx context.Context, title string) (*Page, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return loadPage(title)
+}
+
+// Save writes the page's file
+func (FileStore) Save(ctx context.Context, p *Page) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return p.save()
+}
+
+// Delete moves the page's file into the trash directory
+func (FileStore) Delete(ctx context.Context, title string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return deletePage(title)
+}
+
+// Restore moves the page's file back out of the trash directory
+func (FileStore) Restore(ctx context.Context, title string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return restorePage(title)
+}
+
+// Purge removes the page's file from the trash directory
+func (FileStore) Purge(ctx context.Context, title string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return purgePage(title)
+}
+
+// ListTrash scans the trash directory for page files
+func (FileStore) ListTrash(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return listTrash(wikiFromContext(ctx))
+}
+
+// Rename moves the page's file and its history
+func (FileStore) Rename(ctx context.Context, oldTitle, newTitle string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return renamePage(oldTitle, newTitle)
+}
+
+// List scans the directory of the request's wiki for page files
+func (FileStore) List(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return listPages(wikiFromContext(ctx))
+}
+
+// store is the Store used by the handlers
+var store Store = FileStore{}
+
+// dbPath is the database file used by backends that keep pages in a database
+var dbPath = "wiki.db"
+
+// storeBackends maps the names accepted by the -store flag to a function that
+// opens that kind of Store. Backends that need extra dependencies register
+// themselves from files built only with the matching build tag
+var storeBackends = map[string]func() (Store, error){
+	"file": func() (Store, error) { return FileStore{}, nil },
+}
+
+// pageExists reports whether a page with the given title has been saved
+func pageExists(ctx context.Context, title string) bool {
+	_, err := store.Load(ctx, title)
+	return err == nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// testStore runs the behaviour every Store has to share against the store
+// newStore returns, which starts out empty
+func testStore(t *testing.T, newStore func(t *testing.T) Store) {
+	ctx := context.Background()
+
+	t.Run("save and load", func(t *testing.T) {
+		s := newStore(t)
+		if err := s.Save(ctx, &Page{Title: "Foo", Body: []byte("first")}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Save(ctx, &Page{Title: "Foo", Body: []byte("second")}); err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := s.Load(ctx, "Foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(p.Body) != "second" {
+			t.Errorf("body = %q, want %q", p.Body, "second")
+		}
+		if p.ModTime.IsZero() {
+			t.Error("ModTime isn't set")
+		}
+	})
+
+	t.Run("load missing", func(t *testing.T) {
+		s := newStore(t)
+		if _, err := s.Load(ctx, "Missing"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("err = %v, want os.ErrNotExist", err)
+		}
+	})
+
+	t.Run("list sorted", func(t *testing.T) {
+		s := newStore(t)
+		for _, title := range []string{"Charlie", "Alpha", "Bravo"} {
+			if err := s.Save(ctx, &Page{Title: title, Body: []byte(title)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		titles, err := s.List(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"Alpha", "Bravo", "Charlie"}; !reflect.DeepEqual(titles, want) {
+			t.Errorf("List = %v, want %v", titles, want)
+		}
+	})
+
+	t.Run("delete and restore", func(t *testing.T) {
+		s := newStore(t)
+		if err := s.Save(ctx, &Page{Title: "Foo", Body: []byte("body")}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Delete(ctx, "Foo"); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := s.Load(ctx, "Foo"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Load after Delete: err = %v, want os.ErrNotExist", err)
+		}
+		if trash, _ := s.ListTrash(ctx); !reflect.DeepEqual(trash, []string{"Foo"}) {
+			t.Errorf("ListTrash = %v, want [Foo]", trash)
+		}
+
+		if err := s.Restore(ctx, "Foo"); err != nil {
+			t.Fatal(err)
+		}
+		p, err := s.Load(ctx, "Foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(p.Body) != "body" {
+			t.Errorf("restored body = %q, want %q", p.Body, "body")
+		}
+	})
+
+	t.Run("delete missing", func(t *testing.T) {
+		s := newStore(t)
+		if err := s.Delete(ctx, "Missing"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("err = %v, want os.ErrNotExist", err)
+		}
+	})
+
+	t.Run("delete keeps earlier trashed page", func(t *testing.T) {
+		s := newStore(t)
+		for _, body := range []string{"first", "second"} {
+			if err := s.Save(ctx, &Page{Title: "Foo", Body: []byte(body)}); err != nil {
+				t.Fatal(err)
+			}
+			err := s.Delete(ctx, "Foo")
+			if body == "first" && err != nil {
+				t.Fatal(err)
+			}
+			if body == "second" && !errors.Is(err, os.ErrExist) {
+				t.Fatalf("second Delete: err = %v, want os.ErrExist", err)
+			}
+		}
+
+		// The later page is still in place, and once it is moved aside the
+		// earlier one comes back out of the trash as it was
+		if err := s.Rename(ctx, "Foo", "Later"); err != nil {
+			t.Fatalf("the refused delete removed the page: %v", err)
+		}
+		if err := s.Restore(ctx, "Foo"); err != nil {
+			t.Fatal(err)
+		}
+		if p, err := s.Load(ctx, "Foo"); err != nil || string(p.Body) != "first" {
+			t.Errorf("restored page = %v, %v, want the first body", p, err)
+		}
+	})
+
+	t.Run("restore over new page", func(t *testing.T) {
+		s := newStore(t)
+		if err := s.Save(ctx, &Page{Title: "Foo", Body: []byte("old")}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Delete(ctx, "Foo"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Save(ctx, &Page{Title: "Foo", Body: []byte("new")}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.Restore(ctx, "Foo"); !errors.Is(err, os.ErrExist) {
+			t.Errorf("err = %v, want os.ErrExist", err)
+		}
+		if p, _ := s.Load(ctx, "Foo"); p == nil || string(p.Body) != "new" {
+			t.Error("the refused restore changed the new page")
+		}
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		s := newStore(t)
+		if err := s.Save(ctx, &Page{Title: "Foo", Body: []byte("body")}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Delete(ctx, "Foo"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Purge(ctx, "Foo"); err != nil {
+			t.Fatal(err)
+		}
+
+		if trash, _ := s.ListTrash(ctx); len(trash) != 0 {
+			t.Errorf("ListTrash = %v, want it empty", trash)
+		}
+		if err := s.Purge(ctx, "Foo"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("second Purge: err = %v, want os.ErrNotExist", err)
+		}
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		s := newStore(t)
+		if err := s.Save(ctx, &Page{Title: "Old", Body: []byte("body")}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Rename(ctx, "Old", "New"); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := s.Load(ctx, "Old"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Load of the old title: err = %v, want os.ErrNotExist", err)
+		}
+		if p, err := s.Load(ctx, "New"); err != nil || string(p.Body) != "body" {
+			t.Errorf("Load of the new title = %v, %v", p, err)
+		}
+	})
+
+	t.Run("rename over existing", func(t *testing.T) {
+		s := newStore(t)
+		for _, title := range []string{"Old", "Taken"} {
+			if err := s.Save(ctx, &Page{Title: title, Body: []byte(title)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := s.Rename(ctx, "Old", "Taken"); !errors.Is(err, os.ErrExist) {
+			t.Errorf("err = %v, want os.ErrExist", err)
+		}
+		if p, _ := s.Load(ctx, "Taken"); p == nil || string(p.Body) != "Taken" {
+			t.Error("the refused rename overwrote the other page")
+		}
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		s := newStore(t)
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		if err := s.Save(cancelled, &Page{Title: "Foo", Body: []byte("body")}); err == nil {
+			t.Error("Save with a cancelled context succeeded")
+		}
+	})
+}
+
+func TestFileStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		useTempWiki(t)
+		return FileStore{}
+	})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// taggedPage is a page listed under one of its tags
+type taggedPage struct {
+	Title string
+	Meta  pageMeta
+}
+
+// tagIndex maps each wiki's tags, lowercased, to the pages carrying them. A
+// wiki's index is built by scanning its pages the first time it is needed,
+// and thrown away whenever one of its pages changes
+type tagIndex struct {
+	mu     sync.Mutex
+	byWiki map[string]map[string][]taggedPage
+}
+
+// tags is the index behind /tags
+var tags = &tagIndex{byWiki: map[string]map[string][]taggedPage{}}
+
+// Drops the index of a wiki whenever one of its pages changes
+func init() {
+	changeHooks = append(changeHooks, tags.invalidate)
+}
+
+// invalidate throws away the index of the wiki the page belongs to
+func (ti *tagIndex) invalidate(title string) {
+	wiki, _ := splitWiki(title)
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	delete(ti.byWiki, wiki)
+}
+
+// index returns the tag index of the request's wiki, building it if needed.
+// The lock is held while building so that concurrent requests wait for one
+// scan rather than all making their own
+func (ti *tagIndex) index(ctx context.Context) (map[string][]taggedPage, error) {
+	wiki := wikiFromContext(ctx)
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	if idx, ok := ti.byWiki[wiki]; ok {
+		return idx, nil
+	}
+
+	titles, err := store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := map[string][]taggedPage{}
+	for _, title := range titles {
+		p, err := store.Load(ctx, title)
+		if err != nil {
+			// Cancelled part way through, what was indexed so far would be
+			// wrong, so none of it is kept
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			continue
+		}
+		for _, tag := range p.Meta.Tags {
+			key := strings.ToLower(tag)
+			idx[key] = append(idx[key], taggedPage{Title: title, Meta: p.Meta})
+		}
+	}
+
+	ti.byWiki[wiki] = idx
+	return idx, nil
+}
+
+// parseTags reads the value of a tags front matter line, written either as
+// a list like [go, web] or just go, web
+func parseTags(value string) []string {
+	value = strings.TrimSpace(value)
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+
+	var list []string
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			list = append(list, tag)
+		}
+	}
+	return list
+}
+
+// tagURL returns the URL of the listing of a wiki's pages with the given tag
+func tagURL(wiki, tag string) string {
+	return wikiPath(wiki, "/tags/"+url.PathEscape(strings.ToLower(tag)))
+}
+
+// tagCount is a tag and how many pages carry it, as listed on /tags
+type tagCount struct {
+	Tag   string
+	Pages int
+}
+
+// tagsPage is the data rendered by tags.html. With a Tag it lists the pages
+// carrying it, and without one it lists every tag
+type tagsPage struct {
+	Wiki   string
+	Tag    string
+	Titles []string
+	Tags   []tagCount
+}
+
+// tagsHandler lists every tag used in the wiki, along with how many pages
+// carry each of them
+func tagsHandler(w http.ResponseWriter, r *http.Request) {
+	idx, err := tags.index(r.Context())
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	data := tagsPage{Wiki: wikiFromContext(r.Context())}
+	for tag, pages := range idx {
+		if n := len(visibleTagged(r, pages)); n > 0 {
+			data.Tags = append(data.Tags, tagCount{Tag: tag, Pages: n})
+		}
+	}
+	sort.Slice(data.Tags, func(i, j int) bool { return data.Tags[i].Tag < data.Tags[j].Tag })
+
+	renderTemplate(w, "tags", data)
+}
+
+// tagHandler lists the pages carrying the tag in the URL, ignoring case
+func tagHandler(w http.ResponseWriter, r *http.Request) {
+	idx, err := tags.index(r.Context())
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	tag := strings.ToLower(r.PathValue("tag"))
+	titles := visibleTagged(r, idx[tag])
+	if len(titles) == 0 {
+		notFound(w, r)
+		return
+	}
+
+	renderTemplate(w, "tags", tagsPage{Wiki: wikiFromContext(r.Context()), Tag: tag, Titles: titles})
+}
+
+// visibleTagged returns the titles of the pages the request may see, since
+// private pages aren't listed for those who can't view them
+func visibleTagged(r *http.Request, pages []taggedPage) []string {
+	var titles []string
+	for _, p := range pages {
+		if canViewMeta(r, p.Meta) {
+			titles = append(titles, p.Title)
+		}
+	}
+	return titles
+}
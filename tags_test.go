@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"[go, web]", []string{"go", "web"}},
+		{"go, web", []string{"go", "web"}},
+		{"  [ go ,web ]  ", []string{"go", "web"}},
+		{"single", []string{"single"}},
+		{"[a, , b,]", []string{"a", "b"}},
+		{"[]", nil},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		if got := parseTags(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseTags(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTagPages(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Server", "---\ntags: [Go, web]\n---\nserver\n")
+	savePage(t, "Client", "---\ntags: go\n---\nclient\n")
+	savePage(t, "Notes", "untagged\n")
+	savePage(t, "Secret", "---\nprivate: true\ntags: [go]\n---\nsecret\n")
+	useAuth(t, "admin", "secret")
+
+	// Tags match without regard to case
+	w := serve(httptest.NewRequest(http.MethodGet, "/tags/GO", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /tags/GO: status = %d, want %d", w.Code, http.StatusOK)
+	}
+	body := w.Body.String()
+	for _, title := range []string{"Server", "Client"} {
+		if !strings.Contains(body, `href="/view/`+title+`"`) {
+			t.Errorf("/tags/GO doesn't list %s", title)
+		}
+	}
+	for _, title := range []string{"Notes", "Secret"} {
+		if strings.Contains(body, `href="/view/`+title+`"`) {
+			t.Errorf("/tags/GO lists %s", title)
+		}
+	}
+
+	// The list of every tag counts the pages the reader can see
+	body = serve(httptest.NewRequest(http.MethodGet, "/tags", nil)).Body.String()
+	if !strings.Contains(body, `<a href="/tags/go">go</a> <small>(2 pages)</small>`) {
+		t.Errorf("/tags doesn't count two pages tagged go:\n%s", body)
+	}
+	if !strings.Contains(body, `<a href="/tags/web">web</a> <small>(1 page)</small>`) {
+		t.Errorf("/tags doesn't count one page tagged web:\n%s", body)
+	}
+
+	// Signed in, the private page is listed too
+	r := withBasicAuth(httptest.NewRequest(http.MethodGet, "/tags/go", nil), "admin", "secret")
+	if body := serve(r).Body.String(); !strings.Contains(body, `href="/view/Secret"`) {
+		t.Errorf("/tags/go doesn't list the private page to its editor")
+	}
+
+	if w := serve(httptest.NewRequest(http.MethodGet, "/tags/nothing", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("GET /tags/nothing: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestTagIndexFollowsSaves(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Server", "---\ntags: [go]\n---\nserver\n")
+	serve(httptest.NewRequest(http.MethodGet, "/tags/go", nil))
+
+	// Retagging the page through the wiki moves it between listings
+	serve(postForm("/save/Server", url.Values{"body": {"---\ntags: [rust]\n---\nserver\n"}}))
+	if w := serve(httptest.NewRequest(http.MethodGet, "/tags/go", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("GET /tags/go after retagging: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := serve(httptest.NewRequest(http.MethodGet, "/tags/rust", nil)); !strings.Contains(w.Body.String(), `href="/view/Server"`) {
+		t.Errorf("/tags/rust doesn't list the retagged page")
+	}
+}
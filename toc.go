@@ -0,0 +1,103 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// tocMinHeadings is how many headings a page needs before the view shows a
+// table of contents. 0 turns the table off
+var tocMinHeadings = 3
+
+// tocHeading is a heading found while rendering a page. Text is the heading
+// as plain text, with any inline markup removed
+type tocHeading struct {
+	Level int
+	Slug  string
+	Text  string
+}
+
+// htmlTag matches a single tag in rendered HTML. Everything the renderer
+// writes from the page text is escaped, so any '<' left is one of its tags
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// plainText turns a heading's rendered HTML back into the text it shows
+func plainText(rendered string) string {
+	return html.UnescapeString(htmlTag.ReplaceAllString(rendered, ""))
+}
+
+// slugify turns heading text into an anchor: letters and digits lowercased,
+// with every run of anything else turned into a single hyphen
+func slugify(text string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(unicode.ToLower(r))
+			hyphen = false
+		} else if !hyphen && b.Len() > 0 {
+			b.WriteByte('-')
+			hyphen = true
+		}
+	}
+
+	slug := strings.TrimSuffix(b.String(), "-")
+	if slug == "" {
+		return "section"
+	}
+	return slug
+}
+
+// uniqueSlug returns slug, or if an earlier heading already has it, slug with
+// the first free "-2", "-3" and so on appended
+func (b *mdWriter) uniqueSlug(slug string) string {
+	unique := slug
+	for n := 2; b.slugs[unique]; n++ {
+		unique = slug + "-" + strconv.Itoa(n)
+	}
+	b.slugs[unique] = true
+	return unique
+}
+
+// renderTOC writes the headings as nested lists of links, a heading deeper
+// than the one before it starting a list inside that one's item
+func renderTOC(headings []tocHeading) template.HTML {
+	var b strings.Builder
+	b.WriteString(`<nav class="toc">`)
+
+	// levels holds the heading level of each list that is still open
+	var levels []int
+	for _, h := range headings {
+		switch {
+		case len(levels) == 0 || h.Level > levels[len(levels)-1]:
+			b.WriteString("<ul><li>")
+			levels = append(levels, h.Level)
+		default:
+			// Closes the deeper lists until one at this level or above is
+			// reached. A heading shallower than the very first one still
+			// goes in the outermost list
+			for len(levels) > 1 && h.Level < levels[len(levels)-1] {
+				b.WriteString("</li></ul>")
+				levels = levels[:len(levels)-1]
+			}
+			if h.Level > levels[len(levels)-1] {
+				b.WriteString("<ul><li>")
+				levels = append(levels, h.Level)
+			} else {
+				b.WriteString("</li><li>")
+			}
+		}
+
+		b.WriteString(`<a href="#` + h.Slug + `">` + template.HTMLEscapeString(h.Text) + "</a>")
+	}
+	for range levels {
+		b.WriteString("</li></ul>")
+	}
+
+	b.WriteString("</nav>")
+	return template.HTML(b.String())
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// trashPage is the data rendered by trash.html
+type trashPage struct {
+	Wiki      string
+	Titles    []string
+	CSRFToken string
+}
+
+// trashDir returns the directory deleted pages of the given wiki are moved
+// into
+func trashDir(wiki string) string {
+	return filepath.Join(wikiDir(wiki), "trash")
+}
+
+// trashFile returns the path a page is kept at while it is in the trash
+func trashFile(title string) string {
+	wiki, name := splitWiki(title)
+	return filepath.Join(trashDir(wiki), name+fileExt)
+}
+
+// restorePage moves a page out of the trash. It refuses to overwrite a page
+// that has been created with the same title since, returning an error that
+// matches os.ErrExist
+func restorePage(title string) error {
+	if err := checkFileTitle(title); err != nil {
+		return err
+	}
+
+	unlock := lockPage(title)
+	defer unlock()
+
+	if _, err := os.Stat(pageFile(title)); err == nil {
+		return fmt.Errorf("page %q: %w", title, os.ErrExist)
+	}
+
+	cache.remove(title)
+	return os.Rename(trashFile(title), pageFile(title))
+}
+
+// purgePage permanently removes a page from the trash. Its history goes with
+// it, unless a page with the same title has been created since
+func purgePage(title string) error {
+	if err := checkFileTitle(title); err != nil {
+		return err
+	}
+
+	unlock := lockPage(title)
+	defer unlock()
+
+	if err := os.Remove(trashFile(title)); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(pageFile(title)); os.IsNotExist(err) {
+		return os.RemoveAll(historyDir(title))
+	}
+	return nil
+}
+
+// listTrash scans the trash directory of the given wiki for deleted pages. An
+// empty trash may not have a directory at all
+func listTrash(wiki string) ([]string, error) {
+	titles, err := listTitles(trashDir(wiki))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	return inWiki(wiki, titles), err
+}
+
+// trashHandler lists the deleted pages with buttons to restore or purge each
+// of them. Like those buttons it needs the editor's credentials, since the
+// trash holds private pages along with the rest
+func trashHandler(w http.ResponseWriter, r *http.Request) {
+	titles, err := store.ListTrash(r.Context())
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	renderTemplate(w, "trash", trashPage{Wiki: wikiFromContext(r.Context()), Titles: titles, CSRFToken: csrfToken(w, r)})
+}
+
+// restoreHandler moves a deleted page back out of the trash and shows it
+func restoreHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if !checkForm(w, r) {
+		return
+	}
+
+	// A restored page counts towards the cap like a new one
+	if err := checkRoom(r.Context(), title); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	err := store.Restore(r.Context(), title)
+
+	if errors.Is(err, os.ErrNotExist) {
+		notFound(w, r)
+		return
+	}
+
+	// A new page has taken the title in the meantime
+	if errors.Is(err, os.ErrExist) {
+		http.Error(w, fmt.Sprintf("a page called %s already exists, rename it before restoring this one", pageName(title)), http.StatusConflict)
+		return
+	}
+
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, pageURL("view", title), http.StatusFound)
+}
+
+// purgeHandler permanently removes a page from the trash
+func purgeHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if !checkForm(w, r) {
+		return
+	}
+
+	err := store.Purge(r.Context(), title)
+
+	if errors.Is(err, os.ErrNotExist) {
+		notFound(w, r)
+		return
+	}
+
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, wikiPath(wikiFromContext(r.Context()), "/trash"), http.StatusFound)
+}
+
+// checkForm parses a form posted from one of the buttons on the trash listing
+// or a page, and checks its CSRF token, responding with an error if either
+// fails
+func checkForm(w http.ResponseWriter, r *http.Request) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	if !checkCSRF(r) {
+		http.Error(w, "invalid or missing CSRF token, reload the page and try again", http.StatusForbidden)
+		return false
+	}
+	return true
+}
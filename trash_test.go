@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDeleteRestoreRoundTrip(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Gone", "worth keeping")
+
+	if w := serve(postForm("/delete/Gone", nil)); w.Code != http.StatusFound {
+		t.Fatalf("delete: status = %d, want %d", w.Code, http.StatusFound)
+	}
+	if _, err := os.Stat(pageFile("Gone")); !os.IsNotExist(err) {
+		t.Error("the page file is still in place after deleting it")
+	}
+	if w := serve(httptest.NewRequest(http.MethodGet, "/trash", nil)); !strings.Contains(w.Body.String(), "Gone") {
+		t.Error("the deleted page isn't listed in the trash")
+	}
+
+	w := serve(postForm("/restore/Gone", nil))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/view/Gone" {
+		t.Fatalf("restore: status = %d, Location = %q", w.Code, w.Header().Get("Location"))
+	}
+	if w := serve(httptest.NewRequest(http.MethodGet, "/view/Gone", nil)); !strings.Contains(w.Body.String(), "worth keeping") {
+		t.Error("the restored page doesn't show its body")
+	}
+}
+
+func TestDeleteNeedsCSRFToken(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Kept", "body")
+
+	if w := serve(httptest.NewRequest(http.MethodPost, "/delete/Kept", nil)); w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if _, err := os.Stat(pageFile("Kept")); err != nil {
+		t.Errorf("the page was deleted anyway: %v", err)
+	}
+}
+
+func TestDeleteKeepsEarlierTrashedPage(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Foo", "first")
+	if w := serve(postForm("/delete/Foo", nil)); w.Code != http.StatusFound {
+		t.Fatalf("first delete: status = %d", w.Code)
+	}
+	savePage(t, "Foo", "second")
+
+	if w := serve(postForm("/delete/Foo", nil)); w.Code != http.StatusConflict {
+		t.Errorf("second delete: status = %d, want %d", w.Code, http.StatusConflict)
+	}
+	if data, _ := os.ReadFile(trashFile("Foo")); string(data) != "first" {
+		t.Errorf("trashed copy = %q, want %q", data, "first")
+	}
+}
+
+func TestPurgeRemovesForGood(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Junk", "body")
+	serve(postForm("/delete/Junk", nil))
+
+	if w := serve(postForm("/purge/Junk", nil)); w.Code != http.StatusFound {
+		t.Fatalf("purge: status = %d, want %d", w.Code, http.StatusFound)
+	}
+	if w := serve(postForm("/restore/Junk", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("restore after purge: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestTrashNeedsLogin(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Secret", "---\nprivate: true\n---\nsecret\n")
+	if err := store.Delete(context.Background(), "Secret"); err != nil {
+		t.Fatal(err)
+	}
+	useAuth(t, "admin", "secret")
+
+	w := serve(httptest.NewRequest(http.MethodGet, "/trash", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /trash signed out: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if strings.Contains(w.Body.String(), "Secret") {
+		t.Errorf("the trash shows a deleted private page to anyone")
+	}
+
+	r := withBasicAuth(httptest.NewRequest(http.MethodGet, "/trash", nil), "admin", "secret")
+	if w := serve(r); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `action="/restore/Secret"`) {
+		t.Errorf("GET /trash signed in: status = %d, without the deleted page", w.Code)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// viewsFile is where view counts are saved on shutdown and loaded from on
+// startup. When empty the counts only live in memory
+var viewsFile = ""
+
+// viewCounter counts how many times each page has been viewed. Each count is
+// its own atomic integer, so the common case of bumping a count that already
+// exists only needs the read lock
+type viewCounter struct {
+	mu     sync.RWMutex
+	counts map[string]*uint64
+}
+
+// views is the counter bumped by viewHandler
+var views = &viewCounter{counts: map[string]*uint64{}}
+
+// inc adds one to the view count of the given title
+func (vc *viewCounter) inc(title string) {
+	vc.mu.RLock()
+	n, ok := vc.counts[title]
+	vc.mu.RUnlock()
+
+	if !ok {
+		vc.mu.Lock()
+		// Another request may have created it while waiting for the lock
+		if n, ok = vc.counts[title]; !ok {
+			n = new(uint64)
+			vc.counts[title] = n
+		}
+		vc.mu.Unlock()
+	}
+
+	atomic.AddUint64(n, 1)
+}
+
+// get returns the view count of the given title
+func (vc *viewCounter) get(title string) uint64 {
+	vc.mu.RLock()
+	defer vc.mu.RUnlock()
+
+	if n, ok := vc.counts[title]; ok {
+		return atomic.LoadUint64(n)
+	}
+	return 0
+}
+
+// snapshot returns a copy of every count
+func (vc *viewCounter) snapshot() map[string]uint64 {
+	vc.mu.RLock()
+	defer vc.mu.RUnlock()
+
+	out := make(map[string]uint64, len(vc.counts))
+	for title, n := range vc.counts {
+		out[title] = atomic.LoadUint64(n)
+	}
+	return out
+}
+
+// load replaces the counts with those saved in path. A missing file just
+// means nothing has been saved yet
+func (vc *viewCounter) load(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var saved map[string]uint64
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	vc.counts = make(map[string]*uint64, len(saved))
+	for title, n := range saved {
+		n := n
+		vc.counts[title] = &n
+	}
+	return nil
+}
+
+// save writes the counts to path as JSON, via a temporary file so a crash
+// mid-write leaves the previous counts intact
+func (vc *viewCounter) save(path string) error {
+	data, err := json.Marshal(vc.snapshot())
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".views.*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
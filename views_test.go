@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestViewsAreCounted(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Popular", "body")
+
+	const n = 25
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serve(httptest.NewRequest(http.MethodGet, "/view/Popular", nil))
+		}()
+	}
+	wg.Wait()
+
+	// Checks by link checkers aren't views
+	serve(httptest.NewRequest(http.MethodHead, "/view/Popular", nil))
+
+	if got := views.get("Popular"); got != n {
+		t.Errorf("count = %d, want %d", got, n)
+	}
+}
+
+func TestViewCountsSurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counts.json")
+
+	saved := &viewCounter{counts: map[string]*uint64{}}
+	for i := 0; i < 3; i++ {
+		saved.inc("Foo")
+	}
+	saved.inc("Bar")
+	if err := saved.save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := &viewCounter{counts: map[string]*uint64{}}
+	if err := loaded.load(path); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.get("Foo") != 3 || loaded.get("Bar") != 1 {
+		t.Errorf("loaded counts = %v, want Foo 3 and Bar 1", loaded.snapshot())
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// watchInterval is how often the page files are checked for changes made by
+// something other than the wiki, like an editor or git pull. 0 turns the
+// checks off, leaving the cache and indexes trusted until the next write made
+// through the wiki
+var watchInterval time.Duration
+
+// watched holds the modification time of every page file as the watcher last
+// saw it, keyed by title. It is nil while the watcher isn't running
+var watched struct {
+	mu    sync.Mutex
+	files map[string]time.Time
+}
+
+// Keeps the wiki's own writes from being taken for outside edits
+func init() {
+	changeHooks = append(changeHooks, rememberFile)
+}
+
+// rememberFile records the file of a page the wiki itself has just changed,
+// as it is now
+func rememberFile(title string) {
+	watched.mu.Lock()
+	defer watched.mu.Unlock()
+	if watched.files == nil {
+		return
+	}
+
+	if info, err := os.Stat(pageFile(title)); err == nil {
+		watched.files[title] = info.ModTime()
+	} else {
+		delete(watched.files, title)
+	}
+}
+
+// storeUsesFiles reports whether the store keeps its pages in files under
+// dataDir, looking through the wrappers every store is opened with. Only
+// those files can be watched
+func storeUsesFiles() bool {
+	s := store
+	for {
+		switch w := s.(type) {
+		case FileStore:
+			return true
+		case notifyingStore:
+			s = w.Store
+		case draftStore:
+			s = w.Store
+		case instrumentedStore:
+			s = w.Store
+		default:
+			return false
+		}
+	}
+}
+
+// runWatcher compares the page files of every wiki with how it last saw them
+// every watchInterval until ctx is cancelled. Pages whose files were edited,
+// added or removed are dropped from the cache, and the indexes and live
+// viewers hear about them like any other change. Stores that don't keep
+// pages in files are left alone
+func runWatcher(ctx context.Context) {
+	if watchInterval <= 0 || !storeUsesFiles() {
+		return
+	}
+
+	files, err := scanFiles()
+	if err != nil {
+		log.Printf("watch: %v", err)
+	}
+	watched.mu.Lock()
+	watched.files = files
+	watched.mu.Unlock()
+	defer func() {
+		watched.mu.Lock()
+		watched.files = nil
+		watched.mu.Unlock()
+	}()
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := changedFiles()
+		if err != nil {
+			log.Printf("watch: %v", err)
+			continue
+		}
+		for _, title := range changed {
+			cache.remove(title)
+			log.Printf("watch: %s changed on disk", title)
+		}
+		notify(nil, changed...)
+	}
+}
+
+// changedFiles scans the page files again and returns the titles of those
+// added, removed or modified since the last scan, sorted. A save landing
+// while the files are scanned can at worst be reported once itself
+func changedFiles() ([]string, error) {
+	files, err := scanFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	watched.mu.Lock()
+	defer watched.mu.Unlock()
+
+	var changed []string
+	for title, mod := range files {
+		if old, ok := watched.files[title]; !ok || !old.Equal(mod) {
+			changed = append(changed, title)
+		}
+	}
+	for title := range watched.files {
+		if _, ok := files[title]; !ok {
+			changed = append(changed, title)
+		}
+	}
+	watched.files = files
+
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// scanFiles returns the modification time of the file of every page of every
+// wiki, keyed by title. A wiki's directory that can't be read fails the whole
+// scan, rather than all its pages looking deleted
+func scanFiles() (map[string]time.Time, error) {
+	files := map[string]time.Time{}
+	for _, wiki := range everyWiki() {
+		entries, err := os.ReadDir(wikiDir(wiki))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.IsDir() || !strings.HasSuffix(e.Name(), fileExt) {
+				continue
+			}
+
+			// The file may have been removed since the directory was read
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			files[wikiTitle(wiki, strings.TrimSuffix(e.Name(), fileExt))] = info.ModTime()
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// editOutside rewrites a page's file the way an editor or git pull would,
+// without the wiki knowing. The modification time is moved on explicitly, as
+// a filesystem with coarse timestamps could otherwise record the same one
+func editOutside(t *testing.T, title, src string) {
+	t.Helper()
+
+	path := pageFile(title)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(src), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := info.ModTime().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startWatching records the page files as they are now, as the watcher does
+// when it starts, for the length of the test
+func startWatching(t *testing.T) {
+	t.Helper()
+
+	files, err := scanFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	watched.mu.Lock()
+	watched.files = files
+	watched.mu.Unlock()
+	t.Cleanup(func() {
+		watched.mu.Lock()
+		watched.files = nil
+		watched.mu.Unlock()
+	})
+}
+
+func TestChangedFiles(t *testing.T) {
+	useTempWiki(t)
+	useWikis(t, "docs")
+	savePage(t, "Edited", "before\n")
+	savePage(t, "Saved", "before\n")
+	savePage(t, "Removed", "removed\n")
+	savePage(t, "docs/Guide", "before\n")
+	for _, title := range []string{"Edited", "Saved", "Removed"} {
+		if _, err := loadPage(title); err != nil {
+			t.Fatal(err)
+		}
+	}
+	startWatching(t)
+
+	// The wiki's own saves aren't changes
+	savePage(t, "Saved", "after\n")
+	if changed, err := changedFiles(); err != nil || changed != nil {
+		t.Fatalf("changedFiles after the wiki's own save = %v, %v, want none", changed, err)
+	}
+
+	// Edits, removals and new files are, cached or not and in any wiki
+	editOutside(t, "Edited", "after\n")
+	editOutside(t, "docs/Guide", "after\n")
+	if err := os.Remove(pageFile("Removed")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(pageFile("Added"), []byte("added\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := changedFiles()
+	if want := []string{"Added", "Edited", "Removed", "docs/Guide"}; err != nil || !reflect.DeepEqual(changed, want) {
+		t.Errorf("changedFiles = %v, %v, want %v", changed, err, want)
+	}
+
+	// Each change is only reported once
+	if changed, _ := changedFiles(); changed != nil {
+		t.Errorf("changedFiles again = %v, want none", changed)
+	}
+}
+
+func TestWatcherPicksUpOutsideEdits(t *testing.T) {
+	useTempWiki(t)
+	oldInterval, oldEnabled := watchInterval, cacheEnabled
+	watchInterval, cacheEnabled = time.Millisecond, true
+	defer func() { watchInterval, cacheEnabled = oldInterval, oldEnabled }()
+
+	savePage(t, "Target", "target\n")
+	savePage(t, "Linker", "nothing yet\n")
+	view := func(title string) string {
+		return serve(httptest.NewRequest(http.MethodGet, "/view/"+title, nil)).Body.String()
+	}
+	if strings.Contains(view("Target"), `href="/view/Linker"`) {
+		t.Fatal("Target lists a backlink before any link")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runWatcher(ctx)
+	}()
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	waitFor(t, "the watcher to start", func() bool {
+		watched.mu.Lock()
+		defer watched.mu.Unlock()
+		return watched.files != nil
+	})
+	if body := serve(httptest.NewRequest(http.MethodGet, "/tags/fresh", nil)); body.Code != http.StatusNotFound {
+		t.Fatalf("GET /tags/fresh before any page has the tag: status = %d", body.Code)
+	}
+
+	// The view shows the edit, and the indexes worked out from the old
+	// version hear about it, once the watcher has been round. So do they
+	// about a page that was never loaded
+	editOutside(t, "Linker", "now links to [Target]\n")
+	if err := os.WriteFile(pageFile("Tagged"), []byte("---\ntags: [fresh]\n---\nnew\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the outside edit", func() bool {
+		return strings.Contains(view("Linker"), "now links to") && strings.Contains(view("Target"), `href="/view/Linker"`)
+	})
+	waitFor(t, "the added page's tag", func() bool {
+		return serve(httptest.NewRequest(http.MethodGet, "/tags/fresh", nil)).Code == http.StatusOK
+	})
+}
+
+// waitFor fails the test unless done reports true within a few seconds
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("gave up waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// otherStore is a Store that doesn't count as keeping its pages in files,
+// standing in for the database backends
+type otherStore struct {
+	FileStore
+}
+
+// checkWatcherIgnores fails the test unless the watcher, run with the given
+// store, returns straight away without taking anything out of the cache
+func checkWatcherIgnores(t *testing.T, s Store) {
+	t.Helper()
+
+	oldStore, oldInterval := store, watchInterval
+	store, watchInterval = notifyingStore{draftStore{instrumentedStore{s}}}, time.Millisecond
+	defer func() { store, watchInterval = oldStore, oldInterval }()
+
+	if storeUsesFiles() {
+		t.Fatalf("%T counts as keeping pages in files", s)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	runWatcher(ctx)
+	if ctx.Err() != nil {
+		t.Fatal("the watcher ran until it was stopped")
+	}
+}
+
+func TestWatcherLeavesOtherStoresAlone(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Cached", "cached\n")
+	if _, err := loadPage("Cached"); err != nil {
+		t.Fatal(err)
+	}
+
+	checkWatcherIgnores(t, otherStore{})
+	if _, ok := cache.get("Cached"); !ok {
+		t.Error("the cached page was dropped")
+	}
+	if !storeUsesFiles() {
+		t.Error("the file store doesn't count as keeping pages in files")
+	}
+}
@@ -1,46 +1,555 @@
+// Builds without a go.mod default to the ServeMux of Go 1.21 and earlier,
+// which doesn't understand the method and wildcard patterns main registers
+//go:debug httpmuxgo121=0
+
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
 	"errors"
+	"flag"
+	"fmt"
 	"html/template"
-	"io/ioutil"
+	"io"
+	"io/fs"
 	"log"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
 )
 
-// Page holds the title and body of a web page
+// Page holds the title and body of a web page, along with when it was last
+// written to disk. Meta is the front matter the page was stored with, and
+// Body the rest of it. The other fields are only filled in by the handlers
+// that need them. Title has always been through validateTitle, but as it comes
+// from the URL it only reaches HTML through html/template, which escapes it
+// for wherever it lands, or escaped by hand like the Markdown renderer does
 type Page struct {
-	Title string
-	Body  []byte
+	Title   string
+	Meta    pageMeta
+	Body    []byte
+	ModTime time.Time
+
+	// HTML is the body rendered from Markdown, TOC its table of contents and
+	// Words and Chars its counts, Breadcrumbs the levels of its title and
+	// Backlinks the titles of the pages linking to it, all filled in when the
+	// page is viewed
+	HTML        template.HTML
+	TOC         template.HTML
+	Words       int
+	Chars       int
+	Breadcrumbs []breadcrumb
+	Backlinks   []string
+
+	// CSRFToken is filled in when the page is rendered with a form that
+	// saves it
+	CSRFToken string
+
+	// New is set by editHandler when the page hasn't been created yet. Draft
+	// is when an unsaved draft of it was autosaved, FromDraft is set when the
+	// editor was loaded from that draft and Autosave when the editor should
+	// keep saving drafts
+	New       bool
+	Draft     time.Time
+	FromDraft bool
+	Autosave  bool
+
+	// EditVersion is the Version of the page the editor was opened on, empty
+	// for a new page. When saveHandler finds the page has changed since, the
+	// editor is shown again with what was submitted and Conflict holds the
+	// source saved in the meantime. DiskFull is set instead when the page
+	// couldn't be saved because the disk is full
+	EditVersion string
+	Conflict    []byte
+	DiskFull    bool
+}
+
+// pageStats counts the words and characters in a page's body. Words are runs
+// of anything but whitespace, and characters are Unicode code points rather
+// than bytes
+func pageStats(p *Page) (words, chars int) {
+	body := string(p.Body)
+	return len(strings.Fields(body)), utf8.RuneCountInString(body)
+}
+
+// dataDir is the directory that page files are read from and written to
+var dataDir = "."
+
+// fileExt is the extension given to page files in dataDir
+var fileExt = ".txt"
+
+// maxTitleLength is the longest title, in characters, that a page may have
+var maxTitleLength = 128
+
+// maxBodySize is the largest request body, in bytes, that saveHandler will
+// read before giving up with 413 Request Entity Too Large
+var maxBodySize int64 = 1 << 20
+
+// pageLocks maps each title to a *sync.Mutex so that reads and writes of the
+// same page never interleave
+var pageLocks sync.Map
+
+// shutdownTimeout is how long in-flight requests are given to finish once the
+// server has been asked to stop
+const shutdownTimeout = 10 * time.Second
+
+// embeddedTemplates are the html templates built into the binary, so it can
+// run without the source tree next to it
+//
+//go:embed *.html
+var embeddedTemplates embed.FS
+
+// templateDir is a directory to read the html templates from instead of the
+// embedded ones. When empty the embedded templates are used
+var templateDir = ""
+
+// templateFiles lists the html templates the wiki renders. Every one of them
+// has to be in templateDir, though it may hold others as well
+var templateFiles = []string{"edit.html", "view.html", "list.html", "history.html", "revision.html", "search.html", "notfound.html", "import.html", "diff.html", "trash.html", "tags.html", "recent.html", "orphans.html"}
+
+// layoutFiles are shared by every page: layout.html is the html skeleton each
+// page's "content" is rendered into, and header.html and footer.html are the
+// partials it puts above and below it
+var layoutFiles = []string{"layout.html", "header.html", "footer.html"}
+
+// Parses the embedded html files ahead of time. main parses them again from
+// templateDir if -templates is given
+var templates = func() map[string]*template.Template {
+	t, err := parseTemplates()
+	if err != nil {
+		panic(err)
+	}
+	return t
+}()
+
+// templateFuncs are the functions the html templates can call. Links to
+// pages and other routes go through them so that they point into the right
+// wiki
+var templateFuncs = template.FuncMap{
+	"pageURL":   pageURL,
+	"wikiPath":  wikiPath,
+	"humanTime": humanTime,
+	"tagURL":    tagURL,
+	"plural":    plural,
+	"readOnly":  isReadOnly,
+	"exporting": isExporting,
+	"static":    staticPath,
+	"pageName":  pageName,
+}
+
+// devMode makes renderTemplate re-parse the templates on every request, so
+// edits to the html files show up without a restart
+var devMode = false
+
+// titleChars matches titles made up only of word characters, the only ones a
+// title may use
+var titleChars = regexp.MustCompile(`^\w+$`)
+
+// errUnsafeTitle is returned by the storage functions for a title that could
+// point outside dataDir
+var errUnsafeTitle = errors.New("page title is not safe to use as a file name")
+
+// checkFileTitle makes sure a title can't escape dataDir when it is turned
+// into a file name. The handlers already only pass word characters, but the
+// storage functions don't rely on that. The title of a page in another wiki
+// has to name one of wikiNames
+func checkFileTitle(title string) error {
+	wiki, name := splitWiki(title)
+	if wiki != "" && !wikiNames[wiki] {
+		return errUnsafeTitle
+	}
+
+	switch {
+	case name == "", name == ".", strings.Contains(name, ".."):
+		return errUnsafeTitle
+	case strings.ContainsAny(name, `/\`+"\x00"), strings.ContainsRune(name, filepath.Separator):
+		return errUnsafeTitle
+	}
+	return nil
 }
 
-// Parses the html files ahead of time
-var templates = template.Must(template.ParseFiles("edit.html", "view.html"))
+// pageFile returns the path of the text file backing the page with the given
+// title inside its wiki's directory
+func pageFile(title string) string {
+	wiki, name := splitWiki(title)
+	return filepath.Join(wikiDir(wiki), name+fileExt)
+}
 
-// Sets up a regular expression to compile path names later
-var validPath = regexp.MustCompile("^/(edit|save|view)/([\\w]+)$")
+// lockPage acquires the lock for the page with the given title and returns
+// the function that releases it
+func lockPage(title string) func() {
+	l, _ := pageLocks.LoadOrStore(title, &sync.Mutex{})
+	mu := l.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
 
 // save gets a title and a body and creates a text file from that
 func (p *Page) save() error {
-	filename := p.Title + ".txt"
+	if err := checkFileTitle(p.Title); err != nil {
+		return err
+	}
+	filename := pageFile(p.Title)
+
+	// Holds the page lock until the write is done so concurrent saves of the
+	// same title can't mangle each other
+	unlock := lockPage(p.Title)
+	defer unlock()
+
+	// Writes the body to a temporary file next to the real one, so a crash or
+	// a full disk part way through never leaves a truncated page behind
+	_, name := splitWiki(p.Title)
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+name+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	// Removes the temporary file if anything goes wrong before the rename.
+	// Once it has been renamed this is a harmless no-op
+	defer os.Remove(tmp.Name())
+
+	// Encrypts the page first if -encrypt-key is set
+	data, err := sealPage(p.Source())
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
 
 	// 0600 indicates that the file should be created with read-write
 	// permissions for the current user only
-	return ioutil.WriteFile(filename, p.Body, 0600)
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	// Keeps a copy of the version about to be replaced in the page history
+	if err := saveRevision(p.Title); err != nil {
+		return err
+	}
+
+	// Swaps the new content in place of the old, which is atomic on POSIX
+	// filesystems
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return err
+	}
+
+	// Picks up the modification time the filesystem recorded for the write
+	if info, err := os.Stat(filename); err == nil {
+		p.ModTime = info.ModTime()
+	}
+
+	// Keeps the cache in step with what is now on disk
+	cache.put(p)
+	return nil
 }
 
 // loadPage searches for a specific file and returns the title and body of
 // that page if it exists, otherwise it returns an error
 func loadPage(title string) (*Page, error) {
-	filename := title + ".txt"
-	body, err := ioutil.ReadFile(filename)
+	if err := checkFileTitle(title); err != nil {
+		return nil, err
+	}
+	filename := pageFile(title)
+
+	// Waits for any in-progress save of the same title to finish
+	unlock := lockPage(title)
+	defer unlock()
+
+	// Pages that have been loaded or saved before are served from memory
+	if p, ok := cache.get(title); ok {
+		return p, nil
+	}
+
+	f, err := os.Open(filename)
+
+	// Checks if the page could be opened
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(f)
 
 	// Checks if the read failed
 	if err != nil {
 		return nil, err
 	}
-	return &Page{Title: title, Body: body}, nil
+
+	src, err := openPage(data)
+	if err != nil {
+		return nil, fmt.Errorf("page %q: %w", title, err)
+	}
+
+	p := pageFromSource(title, src)
+	p.ModTime = info.ModTime()
+	cache.put(p)
+	return p, nil
+}
+
+// deletePage moves the text file backing the page with the given title into
+// the trash, from where restorePage can bring it back. If no such page exists
+// the returned error matches os.ErrNotExist. An earlier page with the same
+// title still in the trash is never overwritten, the error matches
+// os.ErrExist instead
+func deletePage(title string) error {
+	if err := checkFileTitle(title); err != nil {
+		return err
+	}
+
+	unlock := lockPage(title)
+	defer unlock()
+
+	// Checked first so a missing page isn't hidden behind an error creating
+	// the trash directory
+	if _, err := os.Stat(pageFile(title)); err != nil {
+		return err
+	}
+	if _, err := os.Stat(trashFile(title)); err == nil {
+		return fmt.Errorf("page %q: %w", title, os.ErrExist)
+	}
+	if err := os.MkdirAll(filepath.Dir(trashFile(title)), 0700); err != nil {
+		return err
+	}
+
+	cache.remove(title)
+	return os.Rename(pageFile(title), trashFile(title))
+}
+
+// renamePage moves the page called oldTitle, along with its history, to
+// newTitle. It refuses to overwrite an existing page, returning an error that
+// matches os.ErrExist
+func renamePage(oldTitle, newTitle string) error {
+	if err := checkFileTitle(oldTitle); err != nil {
+		return err
+	}
+	if err := checkFileTitle(newTitle); err != nil {
+		return err
+	}
+
+	// Always takes the two locks in the same order so that two renames going
+	// opposite ways can't deadlock
+	first, second := oldTitle, newTitle
+	if second < first {
+		first, second = second, first
+	}
+	unlockFirst := lockPage(first)
+	defer unlockFirst()
+	unlockSecond := lockPage(second)
+	defer unlockSecond()
+
+	// os.Rename would silently replace the target, so that is checked first
+	if _, err := os.Stat(pageFile(newTitle)); err == nil {
+		return fmt.Errorf("page %q: %w", newTitle, os.ErrExist)
+	}
+
+	if err := os.Rename(pageFile(oldTitle), pageFile(newTitle)); err != nil {
+		return err
+	}
+
+	cache.remove(oldTitle)
+	cache.remove(newTitle)
+	return renameHistory(oldTitle, newTitle)
+}
+
+// listPages scans the directory of the given wiki for page files and returns
+// their titles sorted alphabetically
+func listPages(wiki string) ([]string, error) {
+	titles, err := listTitles(wikiDir(wiki))
+	return inWiki(wiki, titles), err
+}
+
+// listTitles returns the titles of the page files in dir, sorted
+// alphabetically
+func listTitles(dir string) ([]string, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	titles := []string{}
+	for _, f := range files {
+		// Only regular files with the page extension hold pages, anything
+		// else is ignored
+		if f.IsDir() || !strings.HasSuffix(f.Name(), fileExt) {
+			continue
+		}
+		titles = append(titles, strings.TrimSuffix(f.Name(), fileExt))
+	}
+
+	sort.Strings(titles)
+	return titles, nil
+}
+
+// homePage is the title of the page "/" redirects to. When empty, or when
+// the page hasn't been created yet, "/" shows the index instead
+var homePage = ""
+
+// listHandler renders an index of every page in the wiki with links to view
+// each of them. The index is always at /index, and at "/" too unless there is
+// a home page to send visitors to
+func listHandler(w http.ResponseWriter, r *http.Request) {
+	// "/" matches every path that no other route claims, so anything other
+	// than the root itself doesn't exist
+	if r.URL.Path != "/" && r.URL.Path != "/index" {
+		notFound(w, r)
+		return
+	}
+
+	// Only redirects to a home page that exists, since the view of a missing
+	// page redirects on to the editor rather than back here
+	wiki := wikiFromContext(r.Context())
+	if home := canonicalTitle(wikiTitle(wiki, homePage)); r.URL.Path == "/" && homePage != "" && pageExists(r.Context(), home) {
+		http.Redirect(w, r, pageURL("view", home), http.StatusFound)
+		return
+	}
+
+	titles, err := store.List(r.Context())
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	// Bad or missing numbers fall back to the first page at the default size
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+
+	data := paginate(titles, page, size)
+	data.Wiki = wiki
+	data.Notice = indexNotices[r.URL.Query().Get("notice")]
+	renderTemplate(w, "list", data)
+}
+
+// indexNotices are the messages other handlers can have the index show by
+// redirecting to it with ?notice=<key>. Only these fixed messages can be
+// shown, so a crafted link can't put arbitrary text on the page
+var indexNotices = map[string]string{
+	"no-pages": "There are no pages to pick from yet, why not create one?",
+}
+
+// randomHandler sends the user to a page picked at random, or back to the
+// index if the wiki is empty
+func randomHandler(w http.ResponseWriter, r *http.Request) {
+	titles, err := store.List(r.Context())
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	if len(titles) == 0 {
+		http.Redirect(w, r, wikiPath(wikiFromContext(r.Context()), "/index?notice=no-pages"), http.StatusFound)
+		return
+	}
+
+	// The top level math/rand functions are seeded randomly at startup, so
+	// there is no seed to set up here
+	title := titles[rand.Intn(len(titles))]
+	http.Redirect(w, r, pageURL("view", title), http.StatusFound)
+}
+
+// defaultIndexSize is how many titles the index shows per page unless the
+// request asks for something else, and maxIndexSize the most it will show
+const (
+	defaultIndexSize = 50
+	maxIndexSize     = 500
+)
+
+// indexEntry is a single title listed on the index, with its view count
+type indexEntry struct {
+	Title string
+	Views uint64
+}
+
+// indexPage is the data rendered by list.html: one page of the sorted titles
+// of a wiki and an optional notice shown above them
+type indexPage struct {
+	Wiki   string
+	Titles []indexEntry
+	Total  int
+	Page   int
+	Pages  int
+	Size   int
+	Notice string
+}
+
+// HasPrev reports whether there is a page of titles before this one
+func (ip indexPage) HasPrev() bool { return ip.Page > 1 }
+
+// HasNext reports whether there is a page of titles after this one
+func (ip indexPage) HasNext() bool { return ip.Page < ip.Pages }
+
+// Prev returns the number of the previous page of titles
+func (ip indexPage) Prev() int { return ip.Page - 1 }
+
+// Next returns the number of the next page of titles
+func (ip indexPage) Next() int { return ip.Page + 1 }
+
+// paginate returns the page'th block of size titles, counting pages from 1.
+// Out of range values are clamped rather than treated as errors, so asking
+// for page 1000 of a small wiki just shows the last page
+func paginate(titles []string, page, size int) indexPage {
+	if size <= 0 {
+		size = defaultIndexSize
+	}
+	if size > maxIndexSize {
+		size = maxIndexSize
+	}
+
+	// An empty wiki still has one (empty) page
+	pages := (len(titles) + size - 1) / size
+	if pages == 0 {
+		pages = 1
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	if page > pages {
+		page = pages
+	}
+
+	start := (page - 1) * size
+	end := start + size
+	if end > len(titles) {
+		end = len(titles)
+	}
+
+	entries := make([]indexEntry, 0, end-start)
+	for _, title := range titles[start:end] {
+		entries = append(entries, indexEntry{Title: title, Views: views.get(title)})
+	}
+
+	return indexPage{Titles: entries, Total: len(titles), Page: page, Pages: pages, Size: size}
 }
 
 // viewHandler attempts to find a file with a name matching the path on the
@@ -48,107 +557,1061 @@ func loadPage(title string) (*Page, error) {
 // Otherwise it will redirect the user to the edit page for the same topic
 func viewHandler(w http.ResponseWriter, r *http.Request, title string) {
 	// Attempts to load a page with the given title
-	p, err := loadPage(title)
+	p, err := store.Load(r.Context(), title)
 
 	// If no page exists, then the user will be redirected to the edit page
+	if errors.Is(err, os.ErrNotExist) {
+		http.Redirect(w, r, pageURL("edit", title), http.StatusFound)
+		return
+	}
+
+	// A page that exists but can't be read, such as one encrypted with a
+	// different key, mustn't look like one that was never written
 	if err != nil {
-		http.Redirect(w, r, "/edit/"+title, http.StatusFound)
+		serverError(w, err)
 		return
 	}
 
+	if !checkNotExpired(w, p) {
+		return
+	}
+
+	if !canView(r, p) {
+		requestAuth(w)
+		return
+	}
+
+	// Link checkers and monitors asking with HEAD aren't readers
+	if r.Method != http.MethodHead {
+		views.inc(title)
+	}
+
+	// Sent on 304s too, since crawlers revalidate like anyone else
+	if p.Meta.NoIndex {
+		w.Header().Set("X-Robots-Tag", "noindex")
+	}
+
+	// Lets the browser reuse its copy of the page if nothing has changed
+	// since it last fetched it. The backlinks and missing links shown depend
+	// on the rest of the wiki, and private backlinks on who is asking, so
+	// those are part of the version too
+	changed := wikiChanged(p.Wiki())
+	modTime := p.ModTime
+	if changed.After(modTime) {
+		modTime = changed
+	}
+	version := fmt.Sprintf("%s\x00%d\x00%t", p.Source(), changed.UnixNano(), authorized(r))
+	if notModified(w, r, bodyETag([]byte(version)), modTime) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Converts the Markdown source into the html shown to the reader
+	p.HTML, p.TOC = renderMarkdownTOC(p.Wiki(), p.Body)
+	p.Words, p.Chars = pageStats(p)
+	p.Breadcrumbs = breadcrumbs(title)
+
+	// Missing backlinks hide nothing the page itself needs, so the page is
+	// still shown without them
+	if p.Backlinks, err = backlinks.linksTo(r, title); err != nil {
+		log.Printf("request %s: could not find links to %s: %v", requestID(r), title, err)
+	}
+
+	// The rename form on the page needs a token like the edit form does
+	p.CSRFToken = csrfToken(w, r)
+
 	// Renders the html for the given page
 	renderTemplate(w, "view", *p)
 }
 
+// rawHandler sends the source of a page as plain text, front matter and all,
+// exactly as it is stored. Browsers are asked to show it rather than download
+// it, and a Range header gets just that slice of it
+func rawHandler(w http.ResponseWriter, r *http.Request, title string) {
+	p, err := store.Load(r.Context(), title)
+	if errors.Is(err, os.ErrNotExist) {
+		notFound(w, r)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	if !checkNotExpired(w, p) {
+		return
+	}
+
+	if !canView(r, p) {
+		requestAuth(w)
+		return
+	}
+
+	// ServeContent answers Range requests, so a large page can be downloaded
+	// a piece at a time or resumed, and checks the ETag and mod time for
+	// conditional requests as well
+	src := p.Source()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", "inline")
+	w.Header().Set("ETag", bodyETag(src))
+	http.ServeContent(w, r, "", p.ModTime, bytes.NewReader(src))
+}
+
+// bodyETag returns a strong entity tag for a page body, derived from a hash
+// of its content
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified sets the ETag and Last-Modified headers for a response and
+// reports whether the request's If-None-Match or If-Modified-Since headers
+// show the client already has this version. If-None-Match wins when both are
+// sent, as required by RFC 7232
+func notModified(w http.ResponseWriter, r *http.Request, etag string, modTime time.Time) bool {
+	w.Header().Set("ETag", etag)
+	if !modTime.IsZero() {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	// HTTP dates only have second precision, so the file time is truncated
+	// before comparing
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modTime.IsZero() {
+		return !modTime.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
+
 // editHandler displays a page for a user to edit the information for a given
 // topic. Pressing save will create a '/send/' request, which is handled
 // by sendHandler
 func editHandler(w http.ResponseWriter, r *http.Request, title string) {
 	// Attempts to load a page with the given title
-	p, err := loadPage(title)
+	p, err := store.Load(r.Context(), title)
 
 	// If the page doesn't exist then we render a page with the given title
-	// and a blank body
-	if err != nil {
-		p = &Page{Title: title}
+	// and the new page template, or a blank body. Any other failure would
+	// have the editor overwrite a page it couldn't read
+	if errors.Is(err, os.ErrNotExist) {
+		p = pageFromSource(title, newPageTemplate)
+		p.New = true
+
+		// Keeps the casing the page was first asked for as its heading, which
+		// is lost from the title when titles are case-insensitive
+		if heading := queryHeading(r.URL.Query(), title); heading != "" && heading != p.Name() && p.Meta.Title == "" {
+			p.Meta.Title = heading
+		}
+	} else if err != nil {
+		serverError(w, err)
+		return
+	} else {
+		p.EditVersion = p.Version()
 	}
 
+	// A locked page is shown read-only, without drafts being offered or kept
+	if p.Meta.Locked {
+		p.CSRFToken = csrfToken(w, r)
+		renderTemplate(w, "edit", *p)
+		return
+	}
+
+	// An autosaved draft is offered rather than loaded straight away, in
+	// case it is older than changes published since. ?draft=1 loads it
+	p.Autosave = draftEnabled
+	if d, err := loadDraft(title); err == nil && draftEnabled {
+		if r.URL.Query().Get("draft") == "1" {
+			p.Meta, p.Body, p.FromDraft = d.Meta, d.Body, true
+		}
+		p.Draft = d.ModTime
+	}
+
+	// Ties the form to this client so another site can't submit it
+	p.CSRFToken = csrfToken(w, r)
+
 	// Renders the html for the given page
 	renderTemplate(w, "edit", *p)
 }
 
+// newPageTemplate is the source the editor starts new pages with, read from
+// the -new-template file. Empty means new pages start blank
+var newPageTemplate []byte
+
+// normalizeEnabled turns on normalizeBody for saved pages
+var normalizeEnabled = true
+
+// fixUTF8Enabled has fixUTF8 replace invalid UTF-8 in submitted pages, which
+// validatePage would otherwise reject
+var fixUTF8Enabled = false
+
+// fixUTF8 replaces each run of bytes in body that isn't valid UTF-8 with the
+// Unicode replacement character, when fixUTF8Enabled is set. Valid UTF-8 is
+// returned unchanged
+func fixUTF8(body []byte) []byte {
+	if !fixUTF8Enabled || utf8.Valid(body) {
+		return body
+	}
+	return bytes.ToValidUTF8(body, []byte("\uFFFD"))
+}
+
+// normalizeBody converts Windows and old Mac line endings to "\n", strips
+// trailing spaces and tabs from every line and trailing blank lines from the
+// end, leaving a single final newline. Browsers submit textareas with "\r\n"
+// endings, so without this every save would look like a change to every
+// line. Normalizing an already normal body leaves it unchanged
+func normalizeBody(body []byte) []byte {
+	if !normalizeEnabled {
+		return body
+	}
+
+	text := strings.ReplaceAll(string(body), "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+
+	text = strings.TrimRight(strings.Join(lines, "\n"), "\n")
+	if text == "" {
+		return []byte{}
+	}
+	return []byte(text + "\n")
+}
+
 // saveHandler attempts to create a page from a title specified in the path
 // and a body from a form submission
 func saveHandler(w http.ResponseWriter, r *http.Request, title string) {
+	// Stops reading once the body goes past maxBodySize, so a huge POST can't
+	// exhaust memory or disk
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+	if err := r.ParseForm(); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("page body cannot be larger than %d bytes", maxBodySize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Refuses saves that didn't come from one of our own forms
+	if !checkCSRF(r) {
+		http.Error(w, "invalid or missing CSRF token, reload the page and try again", http.StatusForbidden)
+		return
+	}
+
+	if err := checkUnlocked(r.Context(), title); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	// Edits to existing pages are fine, but a new one needs room under the cap
+	if err := checkRoom(r.Context(), title); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
 	body := r.FormValue("body")
 
-	// Creates a Page, converting the body to a byte array in the process
-	p := &Page{Title: title, Body: []byte(body)}
+	// Creates a Page, splitting the front matter off the submitted source
+	p := pageFromSource(title, normalizeBody(fixUTF8([]byte(body))))
+	if err := validatePage(p); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Someone else saved the page after this editor was opened. Rather than
+	// silently overwrite their changes, both versions are shown so they can
+	// be merged, and saving again replaces the version shown
+	if current, ok := editConflict(r, title); ok {
+		p.Conflict = current.Source()
+		p.EditVersion = current.Version()
+		renderEditForm(w, r, p, http.StatusConflict)
+		return
+	}
 
-	// Saves the page to a .txt file
-	err := p.save()
+	// Saves the page to the store
+	err := store.Save(r.Context(), p)
+
+	// A full disk is something the user can't fix by retrying, so rather
+	// than a bare 500 they get their text back to copy somewhere safe
+	if diskFull(err) {
+		log.Printf("request %s failed: %v", requestID(r), err)
+		p.DiskFull = true
+		renderEditForm(w, r, p, http.StatusInsufficientStorage)
+		return
+	}
 
 	// Catches any errors that occurred while saving the new page
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		serverError(w, err)
 		return
 	}
 
+	createStubs(r.Context(), p)
+
+	// The draft has been published, or was thrown away in favour of what
+	// was just saved
+	if err := deleteDraft(title); err != nil {
+		log.Printf("could not remove the draft of %s: %v", title, err)
+	}
+
 	// Redirects the user the view route, which will display the newly
 	// created page
-	http.Redirect(w, r, "/view/"+title, http.StatusFound)
+	http.Redirect(w, r, pageURL("view", title), http.StatusFound)
+}
+
+// diskFull reports whether err means there was no room left to write, either
+// because the disk is full or the user is over their quota
+func diskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
+
+// previewHandler renders a posted body the same way viewHandler renders a
+// saved one and responds with just the resulting html fragment. Nothing is
+// written to disk, so the edit page can show a live preview while typing
+func previewHandler(w http.ResponseWriter, r *http.Request, title string) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// Front matter isn't part of what the page shows
+	_, body := parseSource([]byte(r.FormValue("body")))
+	wiki, _ := splitWiki(title)
+	io.WriteString(w, string(renderMarkdown(wiki, body)))
+}
+
+// deleteHandler moves the page with the given title to the trash and sends
+// the user back to the index
+func deleteHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if !checkForm(w, r) {
+		return
+	}
+
+	if err := checkUnlocked(r.Context(), title); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	err := store.Delete(r.Context(), title)
+
+	// A page that was never there can't be deleted
+	if errors.Is(err, os.ErrNotExist) {
+		notFound(w, r)
+		return
+	}
+
+	// Deleting would throw away the earlier page of the same name in the trash
+	if errors.Is(err, os.ErrExist) {
+		http.Error(w, fmt.Sprintf("an earlier page called %s is already in the trash, restore or purge it before deleting this one", pageName(title)), http.StatusConflict)
+		return
+	}
+
+	// Catches any other errors that occurred while removing the page
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, wikiPath(wikiFromContext(r.Context()), "/index"), http.StatusFound)
+}
+
+// healthHandler reports whether the server is able to serve and store pages,
+// for use by load balancers and liveness probes. It answers 503 when the data
+// directory is missing or can't be written to
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	if err := checkDataDir(); err != nil {
+		log.Printf("health check failed: %v", err)
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// checkDataDir makes sure dataDir exists and that new files can be created in
+// it, by creating and removing an empty one
+func checkDataDir() error {
+	info, err := os.Stat(dataDir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dataDir)
+	}
+
+	f, err := os.CreateTemp(dataDir, ".healthz.*.tmp")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// parseTemplates reads and parses every html file in templateDir, or the
+// embedded templates when it is empty, and checks that none of templateFiles
+// or layoutFiles is missing. Every page defines a template called "content",
+// so each is parsed into a set of its own along with layoutFiles, keyed by
+// its file name
+func parseTemplates() (map[string]*template.Template, error) {
+	var fsys fs.FS = embeddedTemplates
+	source := "the embedded templates"
+	if templateDir != "" {
+		fsys = os.DirFS(templateDir)
+		source = templateDir
+	}
+
+	files, err := fs.Glob(fsys, "*.html")
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no html templates found in %s", source)
+	}
+
+	for _, name := range layoutFiles {
+		if !slices.Contains(files, name) {
+			return nil, fmt.Errorf("template %s is missing from %s", name, source)
+		}
+	}
+
+	sets := map[string]*template.Template{}
+	for _, file := range files {
+		if slices.Contains(layoutFiles, file) {
+			continue
+		}
+
+		// The page comes last so its definitions of blocks like "title"
+		// replace the layout's defaults
+		t, err := template.New(file).Funcs(templateFuncs).ParseFS(fsys, append(slices.Clone(layoutFiles), file)...)
+		if err != nil {
+			return nil, err
+		}
+		if t.Lookup("content") == nil {
+			return nil, fmt.Errorf("template %s in %s doesn't define the content for the layout", file, source)
+		}
+		sets[file] = t
+	}
+
+	// Templates are looked up by file name, so a missing one would otherwise
+	// only show up as an error the first time that page is rendered
+	for _, name := range templateFiles {
+		if sets[name] == nil {
+			return nil, fmt.Errorf("template %s is missing from %s", name, source)
+		}
+	}
+	return sets, nil
+}
+
+// renameHandler moves a page to the title given in the newtitle form value
+// and sends the user to its new URL. Renaming onto a page that already exists
+// is refused with 409 Conflict
+func renameHandler(w http.ResponseWriter, r *http.Request, title string) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !checkCSRF(r) {
+		http.Error(w, "invalid or missing CSRF token, reload the page and try again", http.StatusForbidden)
+		return
+	}
+
+	// The new title goes through the same checks as one in a URL, and the
+	// page stays in the same wiki
+	newTitle := strings.TrimSpace(r.PostFormValue("newtitle"))
+	if err := validateTitle(newTitle); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	wiki, _ := splitWiki(title)
+	newTitle = canonicalTitle(wikiTitle(wiki, newTitle))
+
+	// Nothing to do, the page is already called that
+	if newTitle == title {
+		http.Redirect(w, r, pageURL("view", title), http.StatusFound)
+		return
+	}
+
+	// Moving a locked page away would free its title for anyone to save over
+	if err := checkUnlocked(r.Context(), title); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	err := store.Rename(r.Context(), title, newTitle)
+
+	if errors.Is(err, os.ErrNotExist) {
+		notFound(w, r)
+		return
+	}
+
+	if errors.Is(err, os.ErrExist) {
+		http.Error(w, fmt.Sprintf("a page called %s already exists", pageName(newTitle)), http.StatusConflict)
+		return
+	}
+
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, pageURL("view", newTitle), http.StatusFound)
+}
+
+// render writes the html template from a specified file (pageName) to w,
+// showing the data given, usually a Page, inside the shared layout. It
+// doesn't need a request, so pages can be rendered anywhere, like into the
+// files of a static export
+func render(w io.Writer, pageName string, data interface{}) error {
+	t := templates
+
+	// In dev mode the files are parsed fresh instead of using the cache
+	if devMode {
+		var err error
+		if t, err = parseTemplates(); err != nil {
+			return err
+		}
+	}
+
+	page := t[pageName+".html"]
+	if page == nil {
+		return fmt.Errorf("no template for %s", pageName)
+	}
+
+	// Executes the page's template inside the shared layout
+	return page.ExecuteTemplate(w, "layout", data)
 }
 
 // renderTemplate is a helper function to render an html template from a
-// specified file (pageName) and a specified page (p)
-func renderTemplate(w http.ResponseWriter, pageName string, p Page) {
-	// Executes on one of the cached templates
-	err := templates.ExecuteTemplate(w, pageName+".html", p)
+// specified file (pageName) and the data it displays as the response, or a
+// 500 if it can't be rendered
+func renderTemplate(w http.ResponseWriter, pageName string, data interface{}) {
+	renderTemplateStatus(w, http.StatusOK, pageName, data)
+}
+
+// renderTemplateStatus renders a template like renderTemplate, answering with
+// the given status. The page is rendered into a buffer first, so a template
+// that fails part way through gets a clean 500 rather than the start of the
+// page with an error stuck on the end of it. Knowing the whole page up front
+// also means its length can be sent, which a HEAD request, whose body net/http
+// throws away, is otherwise left without for all but the smallest pages
+func renderTemplateStatus(w http.ResponseWriter, status int, pageName string, data interface{}) {
+	var buf bytes.Buffer
 
 	// Catches any potential errors that occurred executing the
 	// page into the template
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := render(&buf, pageName, data); err != nil {
+		serverError(w, err)
+		return
+	}
+
+	// Every template is html, but they don't all start with a tag that
+	// net/http's content sniffing recognises as such
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// pageURL returns the URL of the given action ("view", "edit" and so on) for
+// a page. The path is escaped by net/url rather than pasted together, so a
+// title can never break out of it into another host or a query string. In a
+// static export a page's view is its exported file
+func pageURL(action, title string) string {
+	wiki, name := splitWiki(title)
+	if exportingStatic && action == "view" {
+		return url.PathEscape(name) + ".html"
 	}
+	u := url.URL{Path: wikiPath(wiki, "/"+action+"/"+name)}
+	return u.String()
+}
+
+// notFound responds with 404 Not Found, rendering the notfound.html template
+// so the user gets a way back to the index instead of a bare error string
+func notFound(w http.ResponseWriter, r *http.Request) {
+	renderTemplateStatus(w, http.StatusNotFound, "notfound", r.URL.Path)
 }
 
-// getTitle gets the title from the request URL path, it also throws an error
-// if the path does not match the regular expression above
-func getTitle(w http.ResponseWriter, r *http.Request) (string, error) {
-	m := validPath.FindStringSubmatch(r.URL.Path)
+// validateTitle checks the rules every title has to follow: it can't be
+// blank, can only use the word characters titleChars allows, can't be longer
+// than maxTitleLength and can't be split into more than maxTitleDepth levels
+func validateTitle(title string) error {
+	if strings.TrimSpace(title) == "" {
+		return errors.New("page title cannot be empty")
+	}
+
+	if !titleChars.MatchString(title) {
+		return errors.New("page title can only contain letters, digits and underscores")
+	}
+
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		return fmt.Errorf("page title cannot be longer than %d characters", maxTitleLength)
+	}
 
-	// The path does not match the pattern so the request is invalid
-	if m == nil {
-		http.NotFound(w, r)
-		return "", errors.New("Invalid Page Title")
+	if maxTitleDepth > 0 && len(titleLevels(title)) > maxTitleDepth {
+		return fmt.Errorf("page title cannot have more than %d levels separated by %s", maxTitleDepth, breadcrumbSep)
 	}
 
-	return m[2], nil // The title is the second subexpression
+	return nil
+}
+
+// validatePage checks a page about to be saved, applying the same rules
+// however it was submitted: the title has to pass validateTitle, and the
+// page's source has to be UTF-8 no larger than maxBodySize. Anything else
+// would render as garbage and trip up search
+func validatePage(p *Page) error {
+	// A page of another wiki is checked by its own title
+	_, name := splitWiki(p.Title)
+	if err := validateTitle(name); err != nil {
+		return err
+	}
+
+	if int64(len(p.Source())) > maxBodySize {
+		return fmt.Errorf("page body cannot be larger than %d bytes", maxBodySize)
+	}
+
+	if !utf8.Valid(p.Source()) {
+		return errors.New("page body is not valid UTF-8, save it in that encoding and try again")
+	}
+	return nil
 }
 
-// makeHandler is a
-func makeHandler(fn func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
+// withTitle adapts a handler for a page route to take the {title} from the
+// route's pattern. Under /w/<name>/ the handler is given the title the page
+// is stored under in that wiki, and with -case-insensitive the lowercased one
+func withTitle(fn func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Attempts to match the path with the pattern
-		m := validPath.FindStringSubmatch(r.URL.Path)
+		title := r.PathValue("title")
 
-		// Invalid path, 404
-		if m == nil {
-			http.NotFound(w, r)
+		// Only word characters can ever make up a page title, so anything
+		// else can't be a page, 404
+		if !titleChars.MatchString(title) {
+			notFound(w, r)
 			return
 		}
 
-		// Execute the call back, passing the title in
-		fn(w, r, m[2])
+		// Titles made of the right characters can still be unusable, 400
+		if err := validateTitle(title); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		key := wikiTitle(wikiFromContext(r.Context()), title)
+		if redirectCanonical(w, r, key) {
+			return
+		}
+		fn(w, r, canonicalTitle(key))
 	}
 }
 
+// handlePage registers h for a page route pattern such as "GET
+// /view/{title}". Reading routes are GET, which also answers HEAD, and routes
+// that change or process submitted content are POST. Requests for the same
+// path with any other method get 405 rather than falling through to "/". A
+// reading route asked for with a trailing slash, like /view/Foo/, redirects
+// to the path without it
+func handlePage(mux *http.ServeMux, pattern string, h http.Handler) {
+	method, path, _ := strings.Cut(pattern, " ")
+	allow := method
+	if method == http.MethodGet {
+		allow = "GET, HEAD"
+		mux.HandleFunc("GET "+path+"/{$}", trimTrailingSlash)
+	}
+
+	mux.Handle(pattern, h)
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	})
+}
+
+// trimTrailingSlash permanently redirects a request to its path without the
+// trailing slash, keeping the query. The path comes back under its wiki and
+// basePath, which were stripped before routing
+func trimTrailingSlash(w http.ResponseWriter, r *http.Request) {
+	target := wikiPath(wikiFromContext(r.Context()), strings.TrimRight(r.URL.Path, "/"))
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
+}
+
+// newHandler sets up every route of the wiki, with static assets served from
+// staticDir, behind the middleware every request passes through
+func newHandler(staticDir string) http.Handler {
+	// Sets up handlers for the index and the routes that act on a page
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", listHandler)
+	mux.HandleFunc("/index", listHandler)
+	mux.Handle("/w/{wiki}/", serveWiki(mux))
+	handlePage(mux, "GET /view/{title}", withTitle(viewHandler))
+	handlePage(mux, "GET /raw/{title}", withTitle(rawHandler))
+	handlePage(mux, "GET /edit/{title}", requireAuth(withTitle(editHandler)))
+	handlePage(mux, "POST /save/{title}", rejectWhenReadOnly(limitRate(requireAuth(withTitle(saveHandler)))))
+	handlePage(mux, "POST /draft/{title}", rejectWhenReadOnly(limitRate(requireAuth(withTitle(draftHandler)))))
+	handlePage(mux, "POST /delete/{title}", rejectWhenReadOnly(limitRate(requireAuth(withTitle(deleteHandler)))))
+	handlePage(mux, "POST /rename/{title}", rejectWhenReadOnly(limitRate(requireAuth(withTitle(renameHandler)))))
+	handlePage(mux, "POST /lock/{title}", rejectWhenReadOnly(limitRate(requireAuth(withTitle(lockHandler)))))
+	handlePage(mux, "GET /history/{title}", withTitle(historyHandler))
+	handlePage(mux, "GET /events/{title}", withTitle(eventsHandler))
+	handlePage(mux, "POST /preview/{title}", withTitle(previewHandler))
+	handlePage(mux, "GET /diff/{title}", withTitle(diffHandler))
+	handlePage(mux, "POST /restore/{title}", rejectWhenReadOnly(limitRate(requireAuth(withTitle(restoreHandler)))))
+	handlePage(mux, "POST /purge/{title}", rejectWhenReadOnly(limitRate(requireAuth(withTitle(purgeHandler)))))
+
+	// Routes that don't take a title are registered as they are
+	mux.Handle("/trash", requireAuth(http.HandlerFunc(trashHandler)))
+	mux.HandleFunc("GET /recent", recentHandler)
+	mux.HandleFunc("GET /recent.xml", recentFeedHandler)
+	mux.HandleFunc("GET /orphans", orphansHandler)
+	mux.HandleFunc("GET /tags", tagsHandler)
+	mux.HandleFunc("GET /tags/{tag}", tagHandler)
+	mux.HandleFunc("/search", searchHandler)
+	mux.HandleFunc("/random", randomHandler)
+	mux.HandleFunc("/export", backupHandler)
+	mux.Handle("/import", rejectWhenReadOnly(limitRate(requireAuth(http.HandlerFunc(importHandler)))))
+	mux.HandleFunc("/healthz", healthHandler)
+	mux.HandleFunc("/robots.txt", robotsHandler)
+	mux.HandleFunc("/favicon.ico", faviconHandler)
+	mux.HandleFunc("/sitemap.xml", sitemapHandler)
+	mux.HandleFunc("/metrics", metricsHandler)
+	mux.Handle("/admin/cache", requireAuth(http.HandlerFunc(cacheAdminHandler)))
+	mux.Handle("/admin/readonly", requireAuth(http.HandlerFunc(readOnlyAdminHandler)))
+
+	// The JSON API lives under its own prefix, separate from the html pages
+	mux.Handle("/api/pages", allowCORS(http.HandlerFunc(apiListPages)))
+	mux.Handle("/api/pages/{title}", allowCORS(rejectWhenReadOnly(limitRate(http.HandlerFunc(apiHandler)))))
+	mux.Handle("/api/", allowCORS(http.HandlerFunc(apiNotFound)))
+
+	// Stylesheets and scripts are served straight from disk. http.Dir refuses
+	// to serve anything outside staticDir, so "../" can't escape it
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(staticDir))))
+
+	// Every request passes through the logging, metrics and compression
+	// middleware on its way to the routes above
+	return withBasePath(recoverPanics(requestIDs(secureHeaders(logRequests(limitConcurrency(instrument(mux, gzipResponses(mux))))))))
+}
+
+// validateAddr checks that addr is a host:port pair with a usable port
+func validateAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	// An empty port would let the OS pick one at random
+	if port == "" {
+		return errors.New("missing port")
+	}
+
+	_, err = net.LookupPort("tcp", port)
+	return err
+}
+
 func main() {
-	// Sets up handlers for the view, edit and save routes
-	http.HandleFunc("/view/", makeHandler(viewHandler))
-	http.HandleFunc("/edit/", makeHandler(editHandler))
-	http.HandleFunc("/save/", makeHandler(saveHandler))
+	// Reads the listen address from the command line, defaulting to port 8000
+	// on every interface
+	addr := flag.String("addr", ":8000", "address (host:port) for the server to listen on")
+	flag.StringVar(&dataDir, "data", dataDir, "directory to store page files in")
+	flag.StringVar(&fileExt, "ext", fileExt, "file extension of page files, such as .txt or .md")
+	storeName := flag.String("store", "file", "where pages are kept: file, or sqlite when built with -tags sqlite")
+	flag.StringVar(&dbPath, "db", dbPath, "database file used by -store=sqlite")
+	flag.Int64Var(&maxBodySize, "max-body-size", maxBodySize, "maximum size in bytes of a saved page body")
+	flag.IntVar(&maxRevisions, "history", maxRevisions, "number of earlier versions kept for each page, 0 disables history")
+	flag.BoolVar(&fixUTF8Enabled, "fix-utf8", fixUTF8Enabled, "replace invalid UTF-8 in saved pages with U+FFFD instead of rejecting them")
+	flag.BoolVar(&normalizeEnabled, "normalize", normalizeEnabled, "normalize line endings and trailing whitespace of saved pages")
+	flag.StringVar(&homePage, "home", homePage, "title of the page / redirects to, such as Home, the index is shown until it exists")
+	flag.BoolVar(&draftEnabled, "drafts", draftEnabled, "autosave drafts from the editor so a crash or closed tab doesn't lose them")
+	encryptKey := flag.String("encrypt-key", "", "hex AES key (16, 24 or 32 bytes) to encrypt pages with on disk, such as from `openssl rand -hex 32`")
+	flag.StringVar(&viewsFile, "views-file", viewsFile, "file to keep page view counts in across restarts, e.g. counts.json")
+	flag.StringVar(&logFormat, "log-format", logFormat, "request log format: text or json")
+	flag.Float64Var(&rateLimit, "rate", rateLimit, "changes per second each client may make after its burst, 0 disables rate limiting")
+	flag.IntVar(&rateBurst, "burst", rateBurst, "changes a client may make in quick succession before -rate applies")
+	flag.BoolVar(&trustProxy, "trust-proxy", trustProxy, "identify clients by X-Forwarded-For for rate limiting, only set behind a proxy")
+	flag.BoolVar(&highlightEnabled, "highlight", highlightEnabled, "highlight the syntax of fenced code blocks that name their language")
+	flag.IntVar(&tocMinHeadings, "toc-headings", tocMinHeadings, "headings a page needs before a table of contents is shown, 0 disables it")
+	flag.StringVar(&templateDir, "templates", templateDir, "directory of html templates to use instead of the built-in ones")
+	flag.BoolVar(&devMode, "dev", devMode, "re-parse html templates on every request")
+	flag.BoolVar(&cacheEnabled, "cache", cacheEnabled, "keep loaded pages in memory, use -cache=false to always read from disk")
+	flag.StringVar(&breadcrumbSep, "breadcrumbs", breadcrumbSep, "separator splitting titles into levels shown as breadcrumbs, such as _ for Projects_Wiki_Setup, empty for none")
+	flag.IntVar(&maxTitleDepth, "max-title-depth", maxTitleDepth, "most levels -breadcrumbs can split a title into, 0 for no limit")
+	flag.BoolVar(&autoStub, "autostub", autoStub, "create a stub page for every link to a missing page when a page is saved")
+	flag.BoolVar(&caseInsensitive, "case-insensitive", caseInsensitive, "treat titles that only differ in case as the same page, stored lowercased, so existing pages with capitals need renaming")
+	flag.IntVar(&recentLimit, "recent", recentLimit, "how many of the most recently changed pages /recent and its feed list")
+	flag.IntVar(&maxPages, "max-pages", maxPages, "most pages each wiki can hold, edits to existing pages still succeed past it, 0 for no limit")
+	flag.IntVar(&maxTitleLength, "max-title-length", maxTitleLength, "maximum number of characters in a page title")
+	corsList := flag.String("cors-origins", "", "comma separated origins, like https://app.example.com, whose scripts may call the API, * for any")
+	flag.BoolVar(&corsCredentials, "cors-credentials", corsCredentials, "let the -cors-origins send credentials with their API requests")
+	wikis := flag.String("wikis", "", "comma separated names of more wikis to serve under /w/<name>/, each in its own subdirectory of -data")
+	newTemplate := flag.String("new-template", "", "file whose contents new pages start with in the editor")
+	flag.StringVar(&contentSecurityPolicy, "csp", contentSecurityPolicy, "Content-Security-Policy header sent with every response, empty for none")
+	flag.StringVar(&frameOptions, "frame-options", frameOptions, "X-Frame-Options header sent with every response, such as DENY or SAMEORIGIN, empty for none")
+	flag.StringVar(&basePath, "base-path", basePath, "path the wiki is served under behind a proxy, like /wiki, which every route and link starts with, the proxy also has to serve <base-path>/robots.txt as /robots.txt")
+	flag.StringVar(&baseURL, "base-url", baseURL, "scheme and host the sitemap links to, like https://wiki.example.com, taken from each request if unset")
+	flag.DurationVar(&watchInterval, "watch", watchInterval, "how often the page files are checked for edits made outside the wiki, like 2s, 0 to never check, only with -store=file")
+	flag.DurationVar(&expiryInterval, "expiry-interval", expiryInterval, "how often pages past the expires time in their front matter are deleted, 0 to never delete them")
+	readOnlyStart := flag.Bool("readonly", false, "start with the wiki read-only, so pages can be viewed but not changed, toggled at runtime with PUT or DELETE /admin/readonly")
+	faviconFile := flag.String("favicon", "", "icon file /favicon.ico serves instead of the built-in one")
+	robotsFile := flag.String("robots", "", "file whose contents /robots.txt serves instead of the built-in rules")
+	exportDir := flag.String("export-static", "", "write every public page as html into this directory, with an index and the static assets, then exit instead of serving")
+	staticDir := flag.String("static", "static", "directory of static assets served under /static/")
+	flag.StringVar(&authUser, "user", "", "username required to edit, save or delete pages, leave unset for an open wiki")
+	flag.StringVar(&authPasswordHash, "password-hash", "", "hex SHA-256 of the password for -user, e.g. from `printf %s secret | sha256sum`")
+	flag.IntVar(&maxConcurrent, "max-concurrent", maxConcurrent, "most requests handled at once, others wait -queue-timeout for a turn and then get a 503, 0 for no limit")
+	flag.DurationVar(&queueTimeout, "queue-timeout", queueTimeout, "how long a request waits for a turn once -max-concurrent requests are being handled")
+	readHeaderTimeout := flag.Duration("read-header-timeout", 5*time.Second, "how long a client has to send the request headers")
+	readTimeout := flag.Duration("read-timeout", 15*time.Second, "how long a client has to send the whole request, body included")
+	writeTimeout := flag.Duration("write-timeout", 30*time.Second, "how long the server has to write a response")
+	idleTimeout := flag.Duration("idle-timeout", 120*time.Second, "how long an idle keep-alive connection stays open")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file, serves HTTPS when set along with -tls-key")
+	tlsKey := flag.String("tls-key", "", "TLS private key file, serves HTTPS when set along with -tls-cert")
+	flag.Parse()
+
+	// Refuses to start on an empty or malformed address rather than letting
+	// the server silently bind to something unexpected
+	if err := validateAddr(*addr); err != nil {
+		log.Fatalf("invalid listen address %q: %v", *addr, err)
+	}
+
+	// Without an extension there'd be no telling page files apart from
+	// anything else in the data directory
+	if !strings.HasPrefix(fileExt, ".") || len(fileExt) < 2 {
+		log.Fatalf("invalid -ext %q, it must start with a dot like .txt", fileExt)
+	}
+
+	if logFormat != "text" && logFormat != "json" {
+		log.Fatalf("invalid -log-format %q, it must be text or json", logFormat)
+	}
+
+	// Swaps the embedded templates for the ones on disk
+	if templateDir != "" {
+		var err error
+		if templates, err = parseTemplates(); err != nil {
+			log.Fatalf("could not load templates: %v", err)
+		}
+	}
+
+	if devMode {
+		log.Println("warning: dev mode is on, templates are re-parsed on every request which is slower")
+		if templateDir == "" {
+			log.Println("warning: -dev without -templates re-parses the embedded templates, so edits to the html files won't show up")
+		}
+	}
+
+	// A certificate without its key (or the other way round) is almost
+	// certainly a typo, so that fails loudly instead of falling back to HTTP
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatal("-tls-cert and -tls-key must be given together")
+	}
+	useTLS := *tlsCert != ""
+
+	// Same goes for a username without a password hash
+	if (authUser == "") != (authPasswordHash == "") {
+		log.Fatal("-user and -password-hash must be given together")
+	}
+
+	// Any other character could never turn up in a title
+	if breadcrumbSep != "" && !titleChars.MatchString(breadcrumbSep) {
+		log.Fatalf("invalid -breadcrumbs %q, it can only contain letters, digits and underscores", breadcrumbSep)
+	}
+
+	if homePage != "" {
+		if err := validateTitle(homePage); err != nil {
+			log.Fatalf("invalid -home %q: %v", homePage, err)
+		}
+	}
+
+	// A burst below one would turn every change away
+	if rateLimit > 0 {
+		if rateBurst < 1 {
+			log.Fatalf("invalid -burst %d, it must be at least 1", rateBurst)
+		}
+		go limiter.cleanupLoop(time.Minute)
+	}
+
+	// Any website's scripts could otherwise act with the user's credentials,
+	// which is the one thing the CORS spec refuses to let "*" mean
+	parseCORSOrigins(*corsList)
+	if corsOrigins["*"] && corsCredentials {
+		log.Fatal("-cors-credentials can't be used with -cors-origins=*, list the origins allowed to send credentials instead")
+	}
+
+	if err := parseWikis(*wikis); err != nil {
+		log.Fatalf("invalid -wikis: %v", err)
+	}
+
+	// Creates the data directory up front so saving into a fresh location
+	// works on the first request
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		log.Fatalf("could not create data directory %q: %v", dataDir, err)
+	}
+	for name := range wikiNames {
+		if err := os.MkdirAll(wikiDir(name), 0700); err != nil {
+			log.Fatalf("could not create the directory of wiki %s: %v", name, err)
+		}
+	}
+
+	if *encryptKey != "" {
+		if err := setEncryptKey(*encryptKey); err != nil {
+			log.Fatalf("invalid -encrypt-key: %v", err)
+		}
+	}
+
+	// A missing template isn't worth refusing to start over, new pages just
+	// start blank as they would without one
+	if *newTemplate != "" {
+		tmpl, err := os.ReadFile(*newTemplate)
+		if err != nil {
+			log.Printf("warning: could not read -new-template, new pages will start blank: %v", err)
+		}
+		newPageTemplate = tmpl
+	}
 
-	// Spins up the server and listens on port 8000
-	log.Fatal(http.ListenAndServe(":8000", nil))
+	if basePath != "" {
+		if err := validateBasePath(basePath); err != nil {
+			log.Fatalf("invalid -base-path %q: %v", basePath, err)
+		}
+		basePath = strings.TrimSuffix(basePath, "/")
+	}
+
+	if baseURL != "" {
+		if err := validateBaseURL(baseURL); err != nil {
+			log.Fatalf("invalid -base-url %q: %v", baseURL, err)
+		}
+	}
+
+	if *faviconFile != "" {
+		if err := loadFavicon(*faviconFile); err != nil {
+			log.Fatalf("could not read -favicon file: %v", err)
+		}
+	}
+
+	if *robotsFile != "" {
+		rules, err := os.ReadFile(*robotsFile)
+		if err != nil {
+			log.Fatalf("could not read -robots file: %v", err)
+		}
+		robotsRules = rules
+	}
+
+	readOnly.Store(*readOnlyStart)
+	if *readOnlyStart {
+		log.Println("wiki is read-only, changes are refused until DELETE /admin/readonly")
+	}
+
+	// Picks up the view counts saved by the last run
+	if viewsFile != "" {
+		if err := views.load(viewsFile); err != nil {
+			log.Printf("could not load view counts from %s: %v", viewsFile, err)
+		}
+	}
+
+	// Opens the storage backend chosen on the command line
+	newStore, ok := storeBackends[*storeName]
+	if !ok {
+		log.Fatalf("unknown -store %q", *storeName)
+	}
+	s, err := newStore()
+	if err != nil {
+		log.Fatalf("could not open %s store: %v", *storeName, err)
+	}
+	store = notifyingStore{draftStore{instrumentedStore{s}}}
+	if watchInterval > 0 && !storeUsesFiles() {
+		log.Fatalf("-watch only works with -store=file, the %s store doesn't keep pages in files", *storeName)
+	}
+
+	// Writes the wiki out as static files instead of serving it
+	if *exportDir != "" {
+		if err := exportStatic(*exportDir, *staticDir); err != nil {
+			log.Fatalf("could not export to %s: %v", *exportDir, err)
+		}
+		log.Printf("exported the wiki to %s", *exportDir)
+		return
+	}
+
+	// The timeouts stop slow or stalled clients, such as a slowloris attack,
+	// from holding connections open forever
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           newHandler(*staticDir),
+		ReadHeaderTimeout: *readHeaderTimeout,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+	}
+
+	// Deletes expired pages and watches for edits made on disk in the
+	// background until the server shuts down
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	var background sync.WaitGroup
+	for _, run := range []func(context.Context){runExpiryJanitor, runWatcher} {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			run(backgroundCtx)
+		}()
+	}
+
+	// Spins up the server in the background and listens on the configured
+	// address. ErrServerClosed only means Shutdown was called below
+	go func() {
+		var err error
+		if useTLS {
+			log.Printf("serving HTTPS on %s", *addr)
+			err = srv.ListenAndServeTLS(*tlsCert, *tlsKey)
+		} else {
+			log.Printf("serving HTTP on %s", *addr)
+			err = srv.ListenAndServe()
+		}
+
+		if err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// Blocks until the process is asked to stop by Ctrl+C, systemd or a
+	// container runtime
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	// Gives in-flight requests, such as a save that is half way through, up
+	// to shutdownTimeout to finish before the server is torn down
+	log.Println("shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// Event streams never finish on their own, so they are ended first
+	events.stop()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Fatalf("graceful shutdown failed: %v", err)
+	}
+	stopBackground()
+	background.Wait()
+
+	// Every request has finished by now, so the counts are final
+	if viewsFile != "" {
+		if err := views.save(viewsFile); err != nil {
+			log.Printf("could not save view counts to %s: %v", viewsFile, err)
+		}
+	}
 }
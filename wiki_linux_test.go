@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestFailedWriteLeavesPageUntouched(t *testing.T) {
+	useTempWiki(t)
+
+	original := []byte("the original body\n")
+	if err := (&Page{Title: "Keep", Body: original}).save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Lowering the file size limit makes the write of the new body fail part
+	// way through, like a full disk would. Writes past the limit raise
+	// SIGXFSZ, which would kill the test unless it is ignored
+	signal.Ignore(syscall.SIGXFSZ)
+	defer signal.Reset(syscall.SIGXFSZ)
+
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_FSIZE, &limit); err != nil {
+		t.Fatal(err)
+	}
+	lowered := limit
+	lowered.Cur = 4096
+	if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &lowered); err != nil {
+		t.Skipf("can't lower the file size limit: %v", err)
+	}
+	err := (&Page{Title: "Keep", Body: bytes.Repeat([]byte("x"), 64<<10)}).save()
+	syscall.Setrlimit(syscall.RLIMIT_FSIZE, &limit)
+
+	if err == nil {
+		t.Fatal("save succeeded past the file size limit")
+	}
+
+	got, err := os.ReadFile(pageFile("Keep"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, original) {
+		t.Errorf("page file is %q after the failed save, want %q", got, original)
+	}
+
+	// The half written temporary file is cleaned up too
+	temps, _ := filepath.Glob(filepath.Join(dataDir, ".Keep.*.tmp"))
+	if len(temps) > 0 {
+		t.Errorf("temporary files left behind: %v", temps)
+	}
+}
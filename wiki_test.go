@@ -0,0 +1,599 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestMain keeps the request log out of the test output and turns off rate
+// limiting, which the tests that need it turn back on
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	rateLimit = 0
+	os.Exit(m.Run())
+}
+
+// useTempWiki points the wiki at an empty data directory for the length of
+// the test, using the file store with a fresh cache and fresh indexes
+func useTempWiki(t *testing.T) {
+	t.Helper()
+
+	oldDir, oldStore := dataDir, store
+	dataDir = t.TempDir()
+	store = notifyingStore{draftStore{instrumentedStore{FileStore{}}}}
+	resetIndexes()
+
+	t.Cleanup(func() {
+		dataDir, store = oldDir, oldStore
+		resetIndexes()
+	})
+}
+
+// resetIndexes throws away everything worked out from the pages of an earlier
+// test, which would otherwise outlive its data directory
+func resetIndexes() {
+	cache.clear()
+	tags.byWiki = map[string]map[string][]taggedPage{}
+	backlinks.byWiki = map[string]*wikiLinks{}
+	pageCount.byWiki = map[string]map[string]bool{}
+	views.counts = map[string]*uint64{}
+}
+
+// savePage saves a page with the given source through the store, failing the
+// test if it can't be
+func savePage(t *testing.T, title, src string) *Page {
+	t.Helper()
+
+	p := pageFromSource(title, []byte(src))
+	if err := store.Save(context.Background(), p); err != nil {
+		t.Fatalf("saving %s: %v", title, err)
+	}
+	return p
+}
+
+// testToken is a CSRF token signed with csrfKey, which postForm sends as both
+// the cookie and the form field
+func testToken() string {
+	return "test." + signCSRF("test")
+}
+
+// serve sends r through every route and middleware of the wiki and returns
+// the response
+func serve(r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	newHandler("static").ServeHTTP(w, r)
+	return w
+}
+
+// postForm returns a POST of form to target, carrying a valid CSRF token
+func postForm(target string, form url.Values) *http.Request {
+	if form == nil {
+		form = url.Values{}
+	}
+	form.Set(csrfField, testToken())
+
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: csrfCookie, Value: testToken()})
+	return r
+}
+
+func TestConcurrentSavesLeaveOneWholeBody(t *testing.T) {
+	useTempWiki(t)
+
+	// Each body is large enough that interleaved writes would show
+	const writers = 20
+	bodies := make([][]byte, writers)
+	for i := range bodies {
+		bodies[i] = bytes.Repeat([]byte(fmt.Sprintf("writer %d\n", i)), 10000)
+	}
+
+	var wg sync.WaitGroup
+	for i := range bodies {
+		wg.Add(1)
+		go func(body []byte) {
+			defer wg.Done()
+			p := &Page{Title: "Race", Body: body}
+			if err := p.save(); err != nil {
+				t.Errorf("save: %v", err)
+			}
+		}(bodies[i])
+	}
+	wg.Wait()
+
+	got, err := os.ReadFile(pageFile("Race"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range bodies {
+		if bytes.Equal(got, body) {
+			return
+		}
+	}
+	t.Fatalf("the page file is not any one of the submitted bodies, it has %d bytes", len(got))
+}
+
+func TestValidateTitle(t *testing.T) {
+	tests := []struct {
+		name  string
+		title string
+		ok    bool
+	}{
+		{"longest allowed", strings.Repeat("a", maxTitleLength), true},
+		{"one too long", strings.Repeat("a", maxTitleLength+1), false},
+		{"empty", "", false},
+		{"only whitespace", "   ", false},
+		{"surrounded by whitespace", " Foo ", false},
+		{"word characters", "Foo_Bar2", true},
+		{"punctuation", "Foo-Bar", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTitle(tt.title)
+			if tt.ok && err != nil {
+				t.Errorf("validateTitle(%q) = %v, want nil", tt.title, err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("validateTitle(%q) = nil, want an error", tt.title)
+			}
+		})
+	}
+}
+
+func TestSaveRejectsOversizedBody(t *testing.T) {
+	useTempWiki(t)
+
+	old := maxBodySize
+	maxBodySize = 100
+	defer func() { maxBodySize = old }()
+
+	w := serve(postForm("/save/Big", url.Values{"body": {strings.Repeat("x", 200)}}))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	if _, err := os.Stat(pageFile("Big")); !os.IsNotExist(err) {
+		t.Errorf("the oversized page was saved anyway")
+	}
+
+	// A body within the limit still saves
+	if w := serve(postForm("/save/Small", url.Values{"body": {"small"}})); w.Code != http.StatusFound {
+		t.Errorf("saving a small page: status = %d, want %d", w.Code, http.StatusFound)
+	}
+}
+
+func TestStorageRejectsUnsafeTitles(t *testing.T) {
+	useTempWiki(t)
+	outside := filepath.Dir(dataDir)
+
+	for _, title := range []string{"../x", "../../etc/passwd", "a/b", `a\b`, "..", ".", "", "a\x00b", "nowiki/Foo"} {
+		if _, err := loadPage(title); !errors.Is(err, errUnsafeTitle) {
+			t.Errorf("loadPage(%q): err = %v, want errUnsafeTitle", title, err)
+		}
+		if err := (&Page{Title: title, Body: []byte("escaped")}).save(); !errors.Is(err, errUnsafeTitle) {
+			t.Errorf("save(%q): err = %v, want errUnsafeTitle", title, err)
+		}
+		if err := deletePage(title); !errors.Is(err, errUnsafeTitle) {
+			t.Errorf("deletePage(%q): err = %v, want errUnsafeTitle", title, err)
+		}
+	}
+
+	// Nothing was written next to the data directory either
+	if _, err := os.Stat(filepath.Join(outside, "x"+fileExt)); !os.IsNotExist(err) {
+		t.Errorf("a page was written outside the data directory")
+	}
+}
+
+func TestNormalizeBody(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"windows endings", "one\r\ntwo\r\n", "one\ntwo\n"},
+		{"old mac endings", "one\rtwo\r", "one\ntwo\n"},
+		{"mixed endings", "one\r\ntwo\nthree\rfour", "one\ntwo\nthree\nfour\n"},
+		{"trailing whitespace", "one  \t\r\ntwo \n", "one\ntwo\n"},
+		{"trailing blank lines", "one\n\n\r\n  \n", "one\n"},
+		{"leading indentation kept", "  code\n", "  code\n"},
+		{"only whitespace", " \r\n\t\n", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := normalizeBody([]byte(tt.in))
+			if string(got) != tt.want {
+				t.Errorf("normalizeBody(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+
+			// A second pass finds nothing left to change
+			if again := normalizeBody(got); string(again) != string(got) {
+				t.Errorf("normalizeBody is not idempotent: %q became %q", got, again)
+			}
+		})
+	}
+}
+
+func TestSaveNormalizesLineEndings(t *testing.T) {
+	useTempWiki(t)
+
+	if w := serve(postForm("/save/Mixed", url.Values{"body": {"one\r\ntwo\rthree  \n\n"}})); w.Code != http.StatusFound {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusFound)
+	}
+	got, err := os.ReadFile(pageFile("Mixed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "one\ntwo\nthree\n"; string(got) != want {
+		t.Errorf("saved %q, want %q", got, want)
+	}
+}
+
+func TestSaveRedirectLocation(t *testing.T) {
+	useTempWiki(t)
+
+	w := serve(postForm("/save/Foo", url.Values{"body": {"body"}}))
+	if w.Code != http.StatusFound {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusFound)
+	}
+	if got := w.Header().Get("Location"); got != "/view/Foo" {
+		t.Errorf("Location = %q, want /view/Foo", got)
+	}
+}
+
+func TestPageURLEscapesTitle(t *testing.T) {
+	// Titles can't hold these today, but the URL must stay well formed if
+	// that ever changes
+	for _, title := range []string{"a b", "a?b=c", "a#b", "%2e%2e"} {
+		u, err := url.Parse(pageURL("view", title))
+		if err != nil {
+			t.Errorf("pageURL(view, %q) doesn't parse: %v", title, err)
+			continue
+		}
+		if u.Host != "" || u.RawQuery != "" || u.Fragment != "" {
+			t.Errorf("pageURL(view, %q) = %q leaks into the host, query or fragment", title, u)
+		}
+		if u.Path != "/view/"+title {
+			t.Errorf("pageURL(view, %q) has path %q", title, u.Path)
+		}
+	}
+}
+
+func TestPageRoutesEnforceMethod(t *testing.T) {
+	useTempWiki(t)
+
+	reading := []string{"view", "raw", "edit", "history", "events", "diff"}
+	changing := []string{"save", "draft", "delete", "rename", "lock", "preview", "restore", "purge"}
+
+	check := func(action, method, allow string) {
+		t.Helper()
+		w := serve(httptest.NewRequest(method, "/"+action+"/Foo", nil))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s /%s/Foo: status = %d, want %d", method, action, w.Code, http.StatusMethodNotAllowed)
+		}
+		if got := w.Header().Get("Allow"); got != allow {
+			t.Errorf("%s /%s/Foo: Allow = %q, want %q", method, action, got, allow)
+		}
+	}
+
+	for _, action := range reading {
+		for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodPatch} {
+			check(action, method, "GET, HEAD")
+		}
+	}
+	for _, action := range changing {
+		for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete} {
+			check(action, method, "POST")
+		}
+	}
+
+	// The right method gets through to the handler. Event streams never
+	// end, so they are left out
+	savePage(t, "Foo", "body")
+	for _, action := range reading {
+		if action == "events" {
+			continue
+		}
+		if w := serve(httptest.NewRequest(http.MethodGet, "/"+action+"/Foo", nil)); w.Code == http.StatusMethodNotAllowed {
+			t.Errorf("GET /%s/Foo was refused", action)
+		}
+	}
+	for _, action := range changing {
+		if w := serve(postForm("/"+action+"/Foo", nil)); w.Code == http.StatusMethodNotAllowed {
+			t.Errorf("POST /%s/Foo was refused", action)
+		}
+	}
+}
+
+func TestRouting(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Foo", "foo body")
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/", http.StatusOK},
+		{"/index", http.StatusOK},
+		{"/view/Foo", http.StatusOK},
+		{"/raw/Foo", http.StatusOK},
+		{"/edit/Foo", http.StatusOK},
+		{"/history/Foo", http.StatusOK},
+		{"/view/Missing", http.StatusFound},
+		{"/view/Foo-Bar", http.StatusNotFound},
+		{"/view/" + strings.Repeat("a", maxTitleLength+1), http.StatusBadRequest},
+		{"/view/", http.StatusNotFound},
+		{"/view/Foo/bar", http.StatusNotFound},
+		{"/nowhere", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		if w := serve(httptest.NewRequest(http.MethodGet, tt.path, nil)); w.Code != tt.status {
+			t.Errorf("GET %s: status = %d, want %d", tt.path, w.Code, tt.status)
+		}
+	}
+
+	// The handler gets the title from the route
+	if w := serve(httptest.NewRequest(http.MethodGet, "/raw/Foo", nil)); w.Body.String() != "foo body" {
+		t.Errorf("GET /raw/Foo = %q, want the page's source", w.Body.String())
+	}
+}
+
+func TestSaveRejectsInvalidUTF8(t *testing.T) {
+	useTempWiki(t)
+
+	for _, body := range []string{"bad \xff byte", "cut short \xe2\x82", "\xc0\xaf overlong"} {
+		w := serve(postForm("/save/Bad", url.Values{"body": {body}}))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("saving %q: status = %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+		if !strings.Contains(w.Body.String(), "not valid UTF-8") {
+			t.Errorf("saving %q: message = %q", body, w.Body.String())
+		}
+	}
+	if _, err := os.Stat(pageFile("Bad")); !os.IsNotExist(err) {
+		t.Errorf("a page with invalid UTF-8 was saved")
+	}
+
+	// Multibyte text is valid and saved as it was sent
+	const text = "héllo wörld, 日本語, 🙂\n"
+	if w := serve(postForm("/save/Good", url.Values{"body": {text}})); w.Code != http.StatusFound {
+		t.Fatalf("saving multibyte text: status = %d, want %d", w.Code, http.StatusFound)
+	}
+	if got, _ := os.ReadFile(pageFile("Good")); string(got) != text {
+		t.Errorf("saved %q, want %q", got, text)
+	}
+}
+
+func TestFixUTF8(t *testing.T) {
+	useTempWiki(t)
+
+	old := fixUTF8Enabled
+	fixUTF8Enabled = true
+	defer func() { fixUTF8Enabled = old }()
+
+	if w := serve(postForm("/save/Fixed", url.Values{"body": {"bad \xff\xfe bytes"}})); w.Code != http.StatusFound {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusFound)
+	}
+	got, err := os.ReadFile(pageFile("Fixed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "bad � bytes\n"; string(got) != want {
+		t.Errorf("saved %q, want %q", got, want)
+	}
+
+	// Valid text isn't touched
+	if got := fixUTF8([]byte("日本語")); string(got) != "日本語" {
+		t.Errorf("fixUTF8 changed valid text to %q", got)
+	}
+}
+
+func TestRawRange(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Big", "0123456789abcdefghij\n")
+
+	r := httptest.NewRequest(http.MethodGet, "/raw/Big", nil)
+	r.Header.Set("Range", "bytes=0-9")
+	w := serve(r)
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusPartialContent)
+	}
+	if got := w.Body.String(); got != "0123456789" {
+		t.Errorf("body = %q, want the first ten bytes", got)
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes 0-9/21" {
+		t.Errorf("Content-Range = %q, want bytes 0-9/21", got)
+	}
+
+	// A later slice, and one past the end
+	r = httptest.NewRequest(http.MethodGet, "/raw/Big", nil)
+	r.Header.Set("Range", "bytes=10-")
+	if w := serve(r); w.Code != http.StatusPartialContent || w.Body.String() != "abcdefghij\n" {
+		t.Errorf("bytes=10-: %d %q", w.Code, w.Body.String())
+	}
+	r = httptest.NewRequest(http.MethodGet, "/raw/Big", nil)
+	r.Header.Set("Range", "bytes=100-")
+	if w := serve(r); w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("bytes=100-: status = %d, want %d", w.Code, http.StatusRequestedRangeNotSatisfiable)
+	}
+
+	// Without a Range header the whole page comes back
+	if w := serve(httptest.NewRequest(http.MethodGet, "/raw/Big", nil)); w.Code != http.StatusOK || w.Header().Get("Accept-Ranges") != "bytes" {
+		t.Errorf("without a range: %d, Accept-Ranges %q", w.Code, w.Header().Get("Accept-Ranges"))
+	}
+}
+
+func TestScriptTitleNeutralized(t *testing.T) {
+	useTempWiki(t)
+	const evil = "<script>alert(1)</script>"
+
+	// A title like that never gets past the routes
+	for _, path := range []string{"/view/", "/edit/", "/save/"} {
+		r := httptest.NewRequest(http.MethodGet, path+url.PathEscape(evil), nil)
+		if path == "/save/" {
+			r = postForm(path+url.PathEscape(evil), url.Values{"body": {"body"}})
+		}
+		w := serve(r)
+		if w.Code < 400 || strings.Contains(w.Body.String(), evil) {
+			t.Errorf("%s %s: status = %d, body has the script: %v", r.Method, path, w.Code, strings.Contains(w.Body.String(), evil))
+		}
+	}
+
+	// Were titles ever allowed to hold one, the templates escape it wherever
+	// it lands, in the heading, links and form actions alike
+	for _, name := range []string{"view", "edit"} {
+		var buf bytes.Buffer
+		if err := render(&buf, name, Page{Title: evil, Body: []byte("body")}); err != nil {
+			t.Fatalf("rendering %s: %v", name, err)
+		}
+		if strings.Contains(buf.String(), "<script>alert") {
+			t.Errorf("the %s template writes the title unescaped", name)
+		}
+	}
+
+	// And so does a heading given by the front matter, which is free text
+	savePage(t, "Safe", "---\ntitle: "+evil+"\n---\nbody\n")
+	if body := serve(httptest.NewRequest(http.MethodGet, "/view/Safe", nil)).Body.String(); strings.Contains(body, "<script>alert") {
+		t.Errorf("the view writes the heading from the front matter unescaped")
+	}
+}
+
+func TestRenderToBuffer(t *testing.T) {
+	var buf bytes.Buffer
+	p := Page{Title: "Buffered", Body: []byte("body"), HTML: "<p>rendered</p>"}
+	if err := render(&buf, "view", p); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); !strings.Contains(out, "<h1>Buffered</h1>") || !strings.Contains(out, "<p>rendered</p>") {
+		t.Errorf("render wrote %q", out)
+	}
+
+	if err := render(&buf, "nonexistent", p); err == nil {
+		t.Error("rendering a template that doesn't exist succeeded")
+	}
+}
+
+func TestTemplateFailureIsCleanError(t *testing.T) {
+	// The template writes the start of a page before it fails
+	broken := template.Must(template.New("layout").Parse(`<html><body><p>started</p>{{.Missing}}</body></html>`))
+	templates["broken.html"] = broken
+	defer delete(templates, "broken.html")
+
+	w := httptest.NewRecorder()
+	renderTemplate(w, "broken", struct{}{})
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if body := w.Body.String(); strings.Contains(body, "started") || !strings.HasPrefix(body, "internal error") {
+		t.Errorf("body = %q, want just the error", body)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q, want the error's text/plain", ct)
+	}
+}
+
+func TestRenderTemplateSetsLength(t *testing.T) {
+	w := httptest.NewRecorder()
+	renderTemplateStatus(w, http.StatusTeapot, "view", Page{Title: "Sized"})
+	if w.Code != http.StatusTeapot {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusTeapot)
+	}
+	if got, want := w.Header().Get("Content-Length"), strconv.Itoa(w.Body.Len()); got != want {
+		t.Errorf("Content-Length = %s, want %s", got, want)
+	}
+}
+
+func TestHeadRequests(t *testing.T) {
+	useTempWiki(t)
+	savePage(t, "Foo", strings.Repeat("a fairly long line of text\n", 500))
+
+	srv := httptest.NewServer(newHandler("static"))
+	defer srv.Close()
+
+	do := func(method, path string) (*http.Response, []byte) {
+		t.Helper()
+		r, _ := http.NewRequest(method, srv.URL+path, nil)
+		r.Header.Set("Accept-Encoding", "identity")
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, body
+	}
+
+	for _, path := range []string{"/view/Foo", "/raw/Foo", "/edit/Foo"} {
+		get, getBody := do(http.MethodGet, path)
+		head, headBody := do(http.MethodHead, path)
+
+		if head.StatusCode != get.StatusCode {
+			t.Errorf("HEAD %s: status = %d, want GET's %d", path, head.StatusCode, get.StatusCode)
+		}
+		if len(headBody) != 0 {
+			t.Errorf("HEAD %s sent a %d byte body", path, len(headBody))
+		}
+		if want := strconv.Itoa(len(getBody)); head.Header.Get("Content-Length") != want {
+			t.Errorf("HEAD %s: Content-Length = %q, want %s", path, head.Header.Get("Content-Length"), want)
+		}
+		if head.Header.Get("Content-Type") != get.Header.Get("Content-Type") {
+			t.Errorf("HEAD %s: Content-Type = %q, want GET's %q", path, head.Header.Get("Content-Type"), get.Header.Get("Content-Type"))
+		}
+	}
+}
+
+func TestTrailingSlashRedirect(t *testing.T) {
+	useTempWiki(t)
+	useWikis(t, "docs")
+	savePage(t, "Foo", "foo")
+
+	tests := []struct {
+		path     string
+		location string
+	}{
+		{"/view/Foo/", "/view/Foo"},
+		{"/raw/Foo/?download=1", "/raw/Foo?download=1"},
+		{"/edit/Foo/", "/edit/Foo"},
+		{"/w/docs/view/Foo/", "/w/docs/view/Foo"},
+	}
+	for _, tt := range tests {
+		w := serve(httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != tt.location {
+			t.Errorf("GET %s: %d to %q, want %d to %q", tt.path, w.Code, w.Header().Get("Location"), http.StatusMovedPermanently, tt.location)
+		}
+	}
+
+	// The redirect lands back under the base path
+	useBasePath(t, "/wiki")
+	if w := serve(httptest.NewRequest(http.MethodGet, "/wiki/view/Foo/", nil)); w.Header().Get("Location") != "/wiki/view/Foo" {
+		t.Errorf("under a base path: redirected to %q, want /wiki/view/Foo", w.Header().Get("Location"))
+	}
+	basePath = ""
+
+	// Paths without the slash are served as before, and a post isn't
+	// redirected since it would lose the form
+	if w := serve(httptest.NewRequest(http.MethodGet, "/view/Foo", nil)); w.Code != http.StatusOK {
+		t.Errorf("GET /view/Foo: status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := serve(httptest.NewRequest(http.MethodGet, "/view/", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("GET /view/: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := serve(postForm("/save/Foo/", url.Values{"body": {"changed"}})); w.Code == http.StatusMovedPermanently {
+		t.Errorf("POST /save/Foo/ was redirected")
+	}
+}
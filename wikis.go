@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// wikiNames are the extra wikis served alongside the main one, set with
+// -wikis. Each is served under /w/<name>/ and keeps its pages in its own
+// subdirectory of dataDir, laid out just like dataDir itself
+var wikiNames = map[string]bool{}
+
+// reservedWikiNames can't be used for a wiki, since dataDir already has
+// directories of those names
+var reservedWikiNames = map[string]bool{"history": true, "trash": true, "drafts": true}
+
+// wikiKey is the context key the wiki a request is for is stored under
+type wikiKey struct{}
+
+// parseWikis sets wikiNames from the comma separated list given to -wikis
+func parseWikis(list string) error {
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !titleChars.MatchString(name) {
+			return fmt.Errorf("wiki name %q can only contain letters, digits and underscores", name)
+		}
+		if reservedWikiNames[name] {
+			return fmt.Errorf("wiki name %q is reserved", name)
+		}
+		wikiNames[name] = true
+	}
+	return nil
+}
+
+// everyWiki returns the names of every wiki served, starting with "" for the
+// main one and then the others sorted
+func everyWiki() []string {
+	return append([]string{""}, sortedKeys(wikiNames)...)
+}
+
+// wikiFromContext returns the wiki a request is for, which is "" for the main
+// wiki
+func wikiFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(wikiKey{}).(string)
+	return name
+}
+
+// wikiTitle returns the title a page of the given wiki is stored under. Pages
+// of the main wiki keep their plain titles, and those of other wikis have the
+// wiki's name and a slash in front, which keeps them apart in every store,
+// cache and counter keyed by title
+func wikiTitle(wiki, title string) string {
+	if wiki == "" {
+		return title
+	}
+	return wiki + "/" + title
+}
+
+// splitWiki splits a title made by wikiTitle back into the wiki and the
+// page's own title
+func splitWiki(title string) (wiki, name string) {
+	if wiki, name, ok := strings.Cut(title, "/"); ok {
+		return wiki, name
+	}
+	return "", title
+}
+
+// Wiki returns the name of the wiki the page belongs to, "" for the main wiki
+func (p Page) Wiki() string {
+	wiki, _ := splitWiki(p.Title)
+	return wiki
+}
+
+// Name returns the page's title within its wiki, which is what its URLs and
+// links use
+func (p Page) Name() string {
+	return pageName(p.Title)
+}
+
+// pageName returns the part of a title inside its wiki, which is what pages
+// are shown as. The name of the wiki is only part of how they are stored
+func pageName(title string) string {
+	_, name := splitWiki(title)
+	return name
+}
+
+// wikiDir returns the directory the pages of the given wiki are kept in
+func wikiDir(wiki string) string {
+	if wiki == "" {
+		return dataDir
+	}
+	return filepath.Join(dataDir, wiki)
+}
+
+// wikiPath returns path, such as "/index", as it is served for the given wiki,
+// under basePath. In a static export the index is its index.html
+func wikiPath(wiki, path string) string {
+	if exportingStatic && path == "/index" {
+		return "index.html"
+	}
+	if wiki == "" {
+		return basePath + path
+	}
+	return basePath + "/w/" + wiki + path
+}
+
+// inWiki turns titles listed from a wiki's directory into the titles its
+// pages are stored under
+func inWiki(wiki string, titles []string) []string {
+	for i, title := range titles {
+		titles[i] = wikiTitle(wiki, title)
+	}
+	return titles
+}
+
+// serveWiki handles /w/<name>/... by serving the rest of the path from mux as
+// if it had been requested from the main wiki, with the name in the
+// request's context so the handlers pick that wiki's pages
+func serveWiki(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("wiki")
+
+		// Wikis don't nest, so /w/a/w/b/ isn't anything either
+		if !wikiNames[name] || wikiFromContext(r.Context()) != "" {
+			notFound(w, r)
+			return
+		}
+
+		r2 := r.Clone(context.WithValue(r.Context(), wikiKey{}, name))
+		r2.URL.Path = strings.TrimPrefix(r.URL.Path, "/w/"+name)
+		r2.URL.RawPath = ""
+		mux.ServeHTTP(w, r2)
+	})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useWikis serves the named wikis alongside the main one for the length of
+// the test, creating their directories in the temporary data directory
+func useWikis(t *testing.T, names ...string) {
+	t.Helper()
+
+	old := wikiNames
+	wikiNames = map[string]bool{}
+	for _, name := range names {
+		wikiNames[name] = true
+		if err := os.MkdirAll(wikiDir(name), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { wikiNames = old })
+}
+
+func TestWikisKeepContentApart(t *testing.T) {
+	useTempWiki(t)
+	useWikis(t, "docs")
+
+	if w := serve(postForm("/save/Foo", url.Values{"body": {"main foo"}})); w.Code != http.StatusFound {
+		t.Fatalf("saving the main Foo: status = %d", w.Code)
+	}
+	w := serve(postForm("/w/docs/save/Foo", url.Values{"body": {"docs foo"}}))
+	if w.Code != http.StatusFound {
+		t.Fatalf("saving the docs Foo: status = %d", w.Code)
+	}
+	if got := w.Header().Get("Location"); got != "/w/docs/view/Foo" {
+		t.Errorf("saving the docs Foo redirected to %q", got)
+	}
+
+	// Each wiki serves its own page under the same title
+	for path, want := range map[string]string{"/raw/Foo": "main foo\n", "/w/docs/raw/Foo": "docs foo\n"} {
+		if w := serve(httptest.NewRequest(http.MethodGet, path, nil)); w.Body.String() != want {
+			t.Errorf("GET %s = %q, want %q", path, w.Body.String(), want)
+		}
+	}
+
+	// And keeps it in its own directory
+	for dir, want := range map[string]string{dataDir: "main foo\n", filepath.Join(dataDir, "docs"): "docs foo\n"} {
+		got, err := os.ReadFile(filepath.Join(dir, "Foo"+fileExt))
+		if err != nil || string(got) != want {
+			t.Errorf("the file in %s holds %q, %v, want %q", dir, got, err, want)
+		}
+	}
+
+	// A page in one wiki doesn't appear in the other
+	serve(postForm("/w/docs/save/OnlyDocs", url.Values{"body": {"docs only"}}))
+	if w := serve(httptest.NewRequest(http.MethodGet, "/raw/OnlyDocs", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("GET /raw/OnlyDocs: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := serve(httptest.NewRequest(http.MethodGet, "/index", nil)); strings.Contains(w.Body.String(), "OnlyDocs") {
+		t.Errorf("the main index lists a page of the docs wiki")
+	}
+	if w := serve(httptest.NewRequest(http.MethodGet, "/w/docs/index", nil)); !strings.Contains(w.Body.String(), "OnlyDocs") {
+		t.Errorf("the docs index doesn't list its own page")
+	}
+}
+
+func TestUnknownWikiIsNotFound(t *testing.T) {
+	useTempWiki(t)
+	useWikis(t, "docs")
+
+	for _, path := range []string{"/w/nowhere/view/Foo", "/w/nowhere/index", "/w/docs/w/docs/view/Foo"} {
+		if w := serve(httptest.NewRequest(http.MethodGet, path, nil)); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: status = %d, want %d", path, w.Code, http.StatusNotFound)
+		}
+	}
+	if w := serve(postForm("/w/nowhere/save/Foo", url.Values{"body": {"lost"}})); w.Code != http.StatusNotFound {
+		t.Errorf("saving into an unknown wiki: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "nowhere")); !os.IsNotExist(err) {
+		t.Errorf("saving into an unknown wiki created its directory")
+	}
+}
+
+func TestParseWikis(t *testing.T) {
+	old := wikiNames
+	defer func() { wikiNames = old }()
+
+	wikiNames = map[string]bool{}
+	if err := parseWikis(" docs, team ,,"); err != nil || !wikiNames["docs"] || !wikiNames["team"] || len(wikiNames) != 2 {
+		t.Errorf("parseWikis = %v, leaving %v", err, wikiNames)
+	}
+	for _, list := range []string{"no-dashes", "trash", "a/b"} {
+		if err := parseWikis(list); err == nil {
+			t.Errorf("parseWikis(%q) succeeded", list)
+		}
+	}
+}